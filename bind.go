@@ -0,0 +1,477 @@
+package q
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kataras/q/errors"
+	"github.com/q-contrib/formBinder"
+)
+
+const (
+	// DefaultMaxBodySize is the default Request.MaxBodySize, 32MB
+	DefaultMaxBodySize int64 = 32 << 20
+	// contentXMLApp header value for XML data, the contentXML is the other one.
+	contentXMLApp = "application/xml"
+	// contentForm header value for url encoded form data.
+	contentForm = "application/x-www-form-urlencoded"
+	// contentMultipart header value for multipart form data.
+	contentMultipart = "multipart/form-data"
+	// contentOctetStream is the Content-Type of a body without a Content-Type header, RFC 7231, 3.1.1.5
+	contentOctetStream = "application/octet-stream"
+)
+
+var (
+	errBindValue           = errors.New("Unable to bind the request, the ctx.Bind accepts only a non-nil pointer to a struct, got: %T")
+	errBindContentType     = errors.New("Unable to bind the request body, the Content-Type: '%s' is not supported")
+	errBindValidationRule  = errors.New("Unknown validation rule: '%s' of the field: '%s'")
+	errBindValidationParam = errors.New("Invalid parameter of the validation rule: '%s' of the field: '%s'")
+	errBindFieldType       = errors.New("Unsupported field type: %s")
+
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+	emailRegexp  = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
+// FieldError is the error of a struct's field which couldn't be binded or validated by the ctx.Bind
+type FieldError struct {
+	// Field is the name of the field, its json, xml, form, param, query or header tag's name or the struct field's name if it has not any of them
+	Field string `json:"field"`
+	// Tag is the validation rule which failed, i.e 'required', or 'type' if the value couldn't be converted to the field's type
+	Tag string `json:"tag"`
+	// Param is the rule's parameter, if any, i.e '3' of the 'min=3'
+	Param string `json:"param,omitempty"`
+	// Message is the human readable description of the error
+	Message string `json:"message"`
+}
+
+// Error returns the Message
+func (e FieldError) Error() string {
+	return e.Message
+}
+
+// FieldErrors is the error which is returned by the ctx.Bind when one or more fields are invalid,
+// it can be rendered as JSON, an array of field errors, i.e ctx.JSON(err)
+type FieldErrors []FieldError
+
+// Error returns the messages of all field errors, one per line
+func (errs FieldErrors) Error() string {
+	msg := ""
+	for i, err := range errs {
+		if i > 0 {
+			msg += "\n"
+		}
+		msg += err.Message
+	}
+	return msg
+}
+
+// BodyTooLargeError is the error of the ctx.Bind and the ctx.FormFile when the request's body is larger than the Request.MaxBodySize,
+// the client should receive a 413 Request Entity Too Large, its StatusCode, i.e ctx.EmitError(err.StatusCode())
+type BodyTooLargeError struct {
+	// MaxSize is the limit of the body, in bytes
+	MaxSize int64
+}
+
+// Error returns the message of the error
+func (e BodyTooLargeError) Error() string {
+	return fmt.Sprintf("The request body is too large, the limit is %d bytes", e.MaxSize)
+}
+
+// StatusCode returns the StatusRequestEntityTooLarge
+func (e BodyTooLargeError) StatusCode() int {
+	return StatusRequestEntityTooLarge
+}
+
+// limitedBody is the request's body which is limited by the http.MaxBytesReader, it keeps whether the limit is exceeded
+type limitedBody struct {
+	io.ReadCloser
+	read     int64
+	maxSize  int64
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= b.maxSize {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+// limitBody limits the request's body to the maxSize bytes, the http.MaxBytesReader is given the underline http.ResponseWriter
+// so the server closes the connection after the response
+func (ctx *Context) limitBody(maxSize int64) *limitedBody {
+	if b, ok := ctx.Request.Body.(*limitedBody); ok {
+		return b
+	}
+	b := &limitedBody{ReadCloser: http.MaxBytesReader(ctx.ResponseWriter.Underline(), ctx.Request.Body, maxSize), maxSize: maxSize}
+	ctx.Request.Body = b
+	return b
+}
+
+// Bind binds the request to the v, which should be a pointer to a struct.
+//
+// The body is decoded by its Content-Type, JSON, XML, url encoded or multipart form, it's limited to the Request.MaxBodySize bytes,
+// then the fields with a `param:"name"`, `query:"name"` or `header:"Name"` tag are setted by the path parameters, the url query and the headers,
+// finally the fields are validated by their `validate:"rules"` tag, the rules are separated by comma:
+//
+// required, min=$n, max=$n, len=$n (the length of strings, slices and maps or the value of numbers),
+// email, uuid, alpha and oneof=$value1 $value2 (separated by space).
+//
+// The rules, except the required, are skipped if the field has its zero value.
+// A body without a Content-Type is an application/octet-stream, which is not supported.
+// Returns the FieldErrors if a value couldn't be converted to its field's type or a field doesn't pass its validation
+// and the BodyTooLargeError if the body is larger than the Request.MaxBodySize.
+func (ctx *Context) Bind(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errBindValue.Format(v)
+	}
+
+	if err := ctx.bindBody(v); err != nil {
+		return err
+	}
+
+	var errs FieldErrors
+	ctx.bindFields(rv.Elem(), ctx.Request.URL.Query(), &errs)
+	if len(errs) == 0 {
+		if err := validateFields(rv.Elem(), "", &errs); err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// bindBody decodes the request body, if any, to the v by the request's Content-Type
+func (ctx *Context) bindBody(v interface{}) error {
+	r := ctx.Request
+	if r.Body == nil || r.ContentLength == 0 {
+		return nil
+	}
+
+	maxSize := ctx.maxBodySize()
+	if r.ContentLength > maxSize {
+		return BodyTooLargeError{MaxSize: maxSize}
+	}
+	body := ctx.limitBody(maxSize)
+	if err := decodeBody(r, v, maxSize); err != nil {
+		if body.exceeded {
+			return BodyTooLargeError{MaxSize: maxSize}
+		}
+		return err
+	}
+	return nil
+}
+
+// decodeBody decodes the request's body to the v by its Content-Type
+func decodeBody(r *http.Request, v interface{}, maxSize int64) error {
+	mediaType := r.Header.Get(contentType)
+	if idx := strings.IndexByte(mediaType, ';'); idx != -1 {
+		mediaType = mediaType[0:idx]
+	}
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		mediaType = contentOctetStream
+	}
+
+	switch mediaType {
+	case contentJSON:
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+			return errReadBody.Format("JSON", err.Error())
+		}
+	case contentXML, contentXMLApp:
+		if err := xml.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+			return errReadBody.Format("XML", err.Error())
+		}
+	case contentForm:
+		if err := r.ParseForm(); err != nil {
+			return errReadBody.Format("Form", err.Error())
+		}
+		if err := formBinder.Decode(r.PostForm, v); err != nil {
+			return errReadBody.Format("Form", err.Error())
+		}
+	case contentMultipart:
+		if err := r.ParseMultipartForm(maxSize); err != nil {
+			return errReadBody.Format("Multipart Form", err.Error())
+		}
+		if err := formBinder.Decode(r.MultipartForm.Value, v); err != nil {
+			return errReadBody.Format("Multipart Form", err.Error())
+		}
+	default:
+		return errBindContentType.Format(mediaType)
+	}
+	return nil
+}
+
+// bindFields sets the fields with a param, query or header tag, the conversion errors are appended to the errs
+func (ctx *Context) bindFields(rv reflect.Value, query url.Values, errs *FieldErrors) {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := rv.Field(i)
+		if f.Anonymous && fv.Kind() == reflect.Struct {
+			ctx.bindFields(fv, query, errs)
+			continue
+		}
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		var values []string
+		name := ""
+		if name = f.Tag.Get("param"); name != "" {
+			if value := ctx.Param(name); value != "" {
+				values = []string{value}
+			}
+		} else if name = f.Tag.Get("query"); name != "" {
+			values = query[name]
+		} else if name = f.Tag.Get("header"); name != "" {
+			values = ctx.Request.Header[http.CanonicalHeaderKey(name)]
+		}
+		if len(values) == 0 || !fv.CanSet() {
+			continue
+		}
+
+		if err := setFieldValues(fv, values); err != nil {
+			*errs = append(*errs, FieldError{
+				Field:   name,
+				Tag:     "type",
+				Message: fmt.Sprintf("%s: '%s' is not a valid %s", name, strings.Join(values, ","), fv.Type()),
+			})
+		}
+	}
+}
+
+// setFieldValues sets the values to a field, all of them if it's a slice, otherwise the first one
+func setFieldValues(fv reflect.Value, values []string) error {
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(fv.Type(), len(values), len(values))
+		for i := range values {
+			if err := setFieldValue(slice.Index(i), values[i]); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+	return setFieldValue(fv, values[0])
+}
+
+// setFieldValue converts the value to the field's type and sets it
+func setFieldValue(fv reflect.Value, value string) error {
+	if fv.Kind() == reflect.Ptr {
+		ptr := reflect.New(fv.Type().Elem())
+		if err := setFieldValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		fv.Set(ptr)
+		return nil
+	}
+
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	default:
+		return errBindFieldType.Format(fv.Type())
+	}
+	return nil
+}
+
+// fieldName returns the name of the field which is used by the FieldError, its first tag's name or the field's name
+func fieldName(f reflect.StructField) string {
+	for _, key := range []string{"json", "xml", "form", "param", "query", "header"} {
+		if name := f.Tag.Get(key); name != "" {
+			if idx := strings.IndexByte(name, ','); idx != -1 {
+				name = name[0:idx]
+			}
+			if name != "" && name != "-" {
+				return name
+			}
+		}
+	}
+	return f.Name
+}
+
+// validateFields validates the fields, and the fields of the nested structs, by their validate tag,
+// the failed rules are appended to the errs, returns an error if a rule is not valid
+func validateFields(rv reflect.Value, prefix string, errs *FieldErrors) error {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := rv.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			// unexported
+			continue
+		}
+		name := prefix + fieldName(f)
+
+		if rules := f.Tag.Get("validate"); rules != "" {
+			if err := validateField(fv, name, rules, errs); err != nil {
+				return err
+			}
+		}
+
+		// the nested structs
+		sv := fv
+		if sv.Kind() == reflect.Ptr && !sv.IsNil() {
+			sv = sv.Elem()
+		}
+		if sv.Kind() == reflect.Struct && sv.Type() != timeType {
+			nestedPrefix := name + "."
+			if f.Anonymous {
+				nestedPrefix = prefix
+			}
+			if err := validateFields(sv, nestedPrefix, errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateField validates a field by its rules, i.e 'required,min=3'
+func validateField(fv reflect.Value, name string, rules string, errs *FieldErrors) error {
+	if !fv.CanInterface() {
+		return nil
+	}
+	isZero := reflect.DeepEqual(fv.Interface(), reflect.Zero(fv.Type()).Interface())
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.TrimSpace(rule)
+		param := ""
+		if idx := strings.IndexByte(rule, '='); idx != -1 {
+			rule, param = rule[0:idx], rule[idx+1:]
+		}
+
+		if rule == "required" {
+			if isZero {
+				*errs = append(*errs, FieldError{Field: name, Tag: rule, Message: fmt.Sprintf("%s is required", name)})
+				return nil
+			}
+			continue
+		}
+		if isZero {
+			// the rest rules are skipped on empty values
+			return nil
+		}
+
+		v := fv
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+
+		message := ""
+		switch rule {
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return errBindValidationParam.Format(rule, name)
+			}
+			value, unit, ok := validationSize(v)
+			if !ok {
+				return errBindValidationRule.Format(rule, name)
+			}
+			if rule == "min" && value < n {
+				message = fmt.Sprintf("%s must be at least %s%s", name, param, unit)
+			} else if rule == "max" && value > n {
+				message = fmt.Sprintf("%s must be at most %s%s", name, param, unit)
+			} else if rule == "len" && value != n {
+				message = fmt.Sprintf("%s must be exactly %s%s", name, param, unit)
+			}
+		case "email", "uuid", "alpha":
+			if v.Kind() != reflect.String {
+				return errBindValidationRule.Format(rule, name)
+			}
+			if (rule == "email" && !emailRegexp.MatchString(v.String())) ||
+				(rule == "uuid" && !isUUID(v.String())) ||
+				(rule == "alpha" && !isAlpha(v.String())) {
+				message = fmt.Sprintf("%s must be a valid %s", name, rule)
+			}
+		case "oneof":
+			value := fmt.Sprintf("%v", v.Interface())
+			found := false
+			for _, allowed := range strings.Fields(param) {
+				if value == allowed {
+					found = true
+					break
+				}
+			}
+			if !found {
+				message = fmt.Sprintf("%s must be one of: %s", name, strings.Join(strings.Fields(param), ", "))
+			}
+		default:
+			return errBindValidationRule.Format(rule, name)
+		}
+
+		if message != "" {
+			*errs = append(*errs, FieldError{Field: name, Tag: rule, Param: param, Message: message})
+		}
+	}
+	return nil
+}
+
+// validationSize returns the value which is checked by the min, max and len rules, the length of strings, slices and maps or the value of numbers,
+// the unit is used by the error's message
+func validationSize(v reflect.Value) (size float64, unit string, ok bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), " characters", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), " items", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), "", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), "", true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), "", true
+	}
+	return 0, "", false
+}
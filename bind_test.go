@@ -0,0 +1,92 @@
+package q
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type bindAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type bindUser struct {
+	ID      int         `param:"id"`
+	Page    int         `query:"page" validate:"min=1,max=10"`
+	Tags    []string    `query:"tag"`
+	Token   string      `header:"X-Token" validate:"required,len=4"`
+	Name    string      `json:"name" validate:"required,min=3"`
+	Email   string      `json:"email" validate:"email"`
+	Role    string      `json:"role" validate:"oneof=admin user"`
+	Address bindAddress `json:"address"`
+}
+
+func TestBind(t *testing.T) {
+	var got bindUser
+	var gotErr error
+	qq := newQ(Entries{Entry{Method: "POST", Path: "/users/:id", Handler: func(ctx *Context) {
+		got = bindUser{}
+		gotErr = ctx.Bind(&got)
+	}}})
+	do := func(target, body string, token string) {
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		if token != "" {
+			req.Header.Set("X-Token", token)
+		}
+		qq.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	do("/users/7?page=2&tag=a&tag=b", `{"name":"kataras","email":"a@b.co","role":"admin","address":{"city":"x"}}`, "abcd")
+	if gotErr != nil || got.ID != 7 || got.Page != 2 || len(got.Tags) != 2 || got.Token != "abcd" || got.Name != "kataras" {
+		t.Fatalf("%v %+v", gotErr, got)
+	}
+	do("/users/7?page=20", `{"name":"ka","email":"nope","role":"root"}`, "")
+	errs, ok := gotErr.(FieldErrors)
+	if !ok || len(errs) != 6 {
+		t.Fatalf("%T %v", gotErr, gotErr)
+	}
+	b, _ := json.Marshal(errs)
+	if !strings.Contains(string(b), `{"field":"page","tag":"max","param":"10","message":"page must be at most 10"}`) || !strings.Contains(string(b), `"address.city"`) {
+		t.Errorf("%s", b)
+	}
+	do("/users/x", `{}`, "abcd")
+	if errs, ok := gotErr.(FieldErrors); !ok || errs[0].Tag != "type" || errs[0].Field != "id" {
+		t.Errorf("%v", gotErr)
+	}
+	qq.Request.MaxBodySize = 10
+	do("/users/7", `{"name":"kataras kataras"}`, "abcd")
+	if gotErr == nil || !strings.Contains(gotErr.Error(), "too large") {
+		t.Errorf("%v", gotErr)
+	}
+}
+
+func TestBindBodyErrors(t *testing.T) {
+	var gotErr error
+	qq := newQ(Entries{Entry{Method: "POST", Path: "/", Handler: func(ctx *Context) {
+		var v struct {
+			Name string `json:"name"`
+		}
+		gotErr = ctx.Bind(&v)
+	}}})
+	qq.Request.MaxBodySize = 10
+	for _, contentLength := range []int64{-1, 0} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{"name":"kataras kataras"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if contentLength < 0 {
+			// unknown length, i.e chunked
+			req.ContentLength = contentLength
+		}
+		qq.ServeHTTP(httptest.NewRecorder(), req)
+		if err, ok := gotErr.(BodyTooLargeError); !ok || err.StatusCode() != StatusRequestEntityTooLarge || err.MaxSize != 10 {
+			t.Errorf("content length %d: %T %v", req.ContentLength, gotErr, gotErr)
+		}
+	}
+
+	qq.Request.MaxBodySize = 0
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"name":"kataras"}`))
+	qq.ServeHTTP(httptest.NewRecorder(), req)
+	if gotErr == nil || !strings.Contains(gotErr.Error(), "'application/octet-stream' is not supported") {
+		t.Errorf("%v", gotErr)
+	}
+}
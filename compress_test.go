@@ -0,0 +1,131 @@
+package q
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	names := []string{"br", "zstd", "gzip", "deflate"}
+	cases := map[string]int{
+		"":                         -1,
+		"gzip":                     2,
+		"gzip, deflate, br, zstd":  0,
+		"gzip;q=1, br;q=0.5":       2,
+		"*":                        0,
+		"*;q=0.5, gzip":            2,
+		"br;q=0, *":                1,
+		"identity":                 -1,
+		"x-gzip":                   2,
+		"deflate;q=0.2, zstd;q=.9": 1,
+	}
+	for accept, want := range cases {
+		if got := negotiateEncoding(accept, names); got != want {
+			t.Errorf("%q: %d want %d", accept, got, want)
+		}
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	big := strings.Repeat("hello compression ", 200)
+	qq := (&Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{Use: Handlers{Compress}, Entries: Entries{
+		Entry{Method: "GET", Path: "/big", Handler: func(ctx *Context) {
+			ctx.SetContentType("text/plain")
+			ctx.Write([]byte(big[:1000]))
+			ctx.Write([]byte(big[1000:]))
+		}},
+		Entry{Method: "GET", Path: "/small", Handler: func(ctx *Context) { ctx.SetContentType("text/plain"); ctx.Write([]byte("small")) }},
+		Entry{Method: "GET", Path: "/png", Handler: func(ctx *Context) { ctx.SetContentType("image/png"); ctx.Write([]byte(big)) }},
+		Entry{Method: "GET", Path: "/sniff", Handler: func(ctx *Context) { ctx.Write([]byte("<html><body>" + big)) }},
+		Entry{Method: "GET", Path: "/status", Handler: func(ctx *Context) { ctx.SetStatusCode(StatusCreated) }},
+		Entry{Method: "GET", Path: "/render", Handler: func(ctx *Context) { ctx.Render("text/plain", big) }},
+	}}, Gzip: true}).Go()
+
+	cases := []struct {
+		path, accept string
+		code         int
+		encoding     string
+		// the decoded body, empty doesn't check it
+		body string
+	}{
+		{"/big", "gzip", 200, "gzip", big},
+		{"/big", "", 200, "", big},
+		{"/small", "gzip", 200, "", "small"},
+		{"/png", "gzip", 200, "", ""},
+		{"/sniff", "gzip", 200, "gzip", ""},
+		{"/status", "gzip", 201, "", ""},
+		{"/nope", "gzip", 404, "", ""},
+		{"/render", "deflate", 200, "deflate", ""},
+	}
+	for _, c := range cases {
+		rec := serve(qq, "GET", c.path, "Accept-Encoding", c.accept)
+		if rec.Code != c.code || rec.Header().Get("Content-Encoding") != c.encoding {
+			t.Errorf("%s %q: got %d %v", c.path, c.accept, rec.Code, rec.Header())
+			continue
+		}
+		if c.encoding != "" && rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s %q: vary %v", c.path, c.accept, rec.Header())
+		}
+		if c.body == "" {
+			continue
+		}
+		var r io.Reader = rec.Body
+		if c.encoding == "gzip" {
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = zr
+		}
+		if b, _ := ioutil.ReadAll(r); string(b) != c.body {
+			t.Errorf("%s %q: body %d", c.path, c.accept, len(b))
+		}
+	}
+	if rec := serve(qq, "GET", "/sniff", "Accept-Encoding", "gzip"); !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("sniff %v", rec.Header())
+	}
+}
+
+func TestPrecompressed(t *testing.T) {
+	dir, remove := staticDir(t, map[string]string{"app.js": `console.log("plain")`, "app.js.gz": "GZIPPED", "app.js.br": "BROTLI"})
+	defer remove()
+	qq := newQ(Entries{Entry{Path: "/s", Method: "GET", Parser: Dir{Directory: dir, Precompressed: true}}})
+	for _, c := range []struct{ accept, body, encoding string }{
+		{"gzip, br", "BROTLI", "br"},
+		{"gzip", "GZIPPED", "gzip"},
+		{"", `console.log("plain")`, ""},
+	} {
+		rec := serve(qq, "GET", "/s/app.js", "Accept-Encoding", c.accept)
+		if rec.Body.String() != c.body || rec.Header().Get("Content-Encoding") != c.encoding || rec.Header().Get("Vary") != "Accept-Encoding" || !strings.Contains(rec.Header().Get("Content-Type"), "javascript") {
+			t.Errorf("%q: %q %v", c.accept, rec.Body.String(), rec.Header())
+		}
+	}
+}
+
+func TestCompressBeginTimeout(t *testing.T) {
+	big := strings.Repeat("x", 5000)
+	h := func(ctx *Context) { ctx.SetContentType("text/plain"); ctx.Write([]byte(big)) }
+	qq := newQ(Entries{
+		Entry{Method: "GET", Path: "/b", Begin: Handlers{Compress}, Handler: h},
+		Entry{Method: "GET", Path: "/t", Timeout: time.Second, Begin: Handlers{Compress}, Handler: h},
+	})
+	for _, p := range []string{"/b", "/t"} {
+		rec := serve(qq, "GET", p, "Accept-Encoding", "gzip")
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("%s %v", p, rec.Header())
+		}
+		zr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(p, err)
+		}
+		b, _ := ioutil.ReadAll(zr)
+		if string(b) != big {
+			t.Errorf("%s body %d", p, len(b))
+		}
+	}
+}
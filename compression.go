@@ -2,9 +2,13 @@ package q
 
 import (
 	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
 )
 
 var gzipWriterPool sync.Pool // used for several methods, usually inside context
@@ -41,3 +45,362 @@ func WriteGzip(w io.Writer, b []byte) (int, error) {
 	ReleaseGzip(gzipWriter)
 	return n, err
 }
+
+// EncodeWriter is the compressor of an Encoder, i.e a *gzip.Writer
+type EncodeWriter interface {
+	io.Writer
+	// Flush sends the pending compressed data to the underline writer, it's used by the streams
+	Flush() error
+	// Close writes the rest of the compressed data, it doesn't close the underline writer
+	Close() error
+}
+
+// Encoder is a compression algorithm of the responses, it's registered to the Compression.Encoders
+type Encoder interface {
+	// Name returns the name of the encoding, the value of the Accept-Encoding and the Content-Encoding headers, i.e "gzip"
+	Name() string
+	// Acquire returns a compressor, usually a pooled one, which writes to the w
+	Acquire(w io.Writer) EncodeWriter
+	// Release closes the compressor and puts it back to the pool
+	Release(EncodeWriter) error
+}
+
+// ResetWriter is a compressor which can be reused, all of the built'n encoders' writers are ResetWriters
+type ResetWriter interface {
+	EncodeWriter
+	Reset(w io.Writer)
+}
+
+// pooledEncoder is the Encoder of the built'n encodings, it keeps its compressors to a pool
+type pooledEncoder struct {
+	name string
+	pool sync.Pool
+}
+
+// NewPooledEncoder returns an Encoder of the name which creates its compressors with the newWriter and keeps them to a pool,
+// i.e the encoders of the compression/brotli and the compression/zstd packages
+func NewPooledEncoder(name string, newWriter func(w io.Writer) ResetWriter) Encoder {
+	e := &pooledEncoder{name: name}
+	e.pool.New = func() interface{} {
+		return newWriter(ioutil.Discard)
+	}
+	return e
+}
+
+func (e *pooledEncoder) Name() string {
+	return e.name
+}
+
+func (e *pooledEncoder) Acquire(w io.Writer) EncodeWriter {
+	writer := e.pool.Get().(ResetWriter)
+	writer.Reset(w)
+	return writer
+}
+
+func (e *pooledEncoder) Release(writer EncodeWriter) error {
+	err := writer.Close()
+	if r, ok := writer.(ResetWriter); ok {
+		// don't keep a reference to the response
+		r.Reset(ioutil.Discard)
+		e.pool.Put(r)
+	}
+	return err
+}
+
+// NewGzipEncoder returns the "gzip" Encoder of the level, -1 (DefaultCompression) or 1 (BestSpeed) to 9 (BestCompression),
+// the invalid levels fallback to the DefaultCompression
+func NewGzipEncoder(level int) Encoder {
+	if _, err := gzip.NewWriterLevel(ioutil.Discard, level); err != nil {
+		level = gzip.DefaultCompression
+	}
+	return NewPooledEncoder("gzip", func(w io.Writer) ResetWriter {
+		writer, _ := gzip.NewWriterLevel(w, level)
+		return writer
+	})
+}
+
+// NewDeflateEncoder returns the "deflate" Encoder of the level, -1 (DefaultCompression) or 1 (BestSpeed) to 9 (BestCompression),
+// the invalid levels fallback to the DefaultCompression.
+// The "deflate" encoding is the zlib format, by the RFC 7230
+func NewDeflateEncoder(level int) Encoder {
+	if _, err := zlib.NewWriterLevel(ioutil.Discard, level); err != nil {
+		level = zlib.DefaultCompression
+	}
+	return NewPooledEncoder("deflate", func(w io.Writer) ResetWriter {
+		writer, _ := zlib.NewWriterLevel(w, level)
+		return writer
+	})
+}
+
+var (
+	// GzipEncoder is the "gzip" Encoder of the default level
+	GzipEncoder = NewGzipEncoder(gzip.DefaultCompression)
+	// DeflateEncoder is the "deflate" Encoder of the default level
+	DeflateEncoder = NewDeflateEncoder(zlib.DefaultCompression)
+)
+
+const (
+	// DefaultCompressionMinSize is the default Compression.MinSize, 1KB
+	DefaultCompressionMinSize = 1024
+)
+
+var (
+	// DefaultEncoders are the default Compression.Encoders, by order of preference,
+	// the "br" and the "zstd" encoders are opt-in, look the compression/brotli and the compression/zstd packages
+	DefaultEncoders = []Encoder{GzipEncoder, DeflateEncoder}
+	// DefaultCompressionTypes are the default Compression.ContentTypes, the text-based content types
+	DefaultCompressionTypes = []string{
+		"text/*",
+		contentJSON,
+		"application/javascript",
+		"application/x-javascript",
+		contentXMLApp,
+		"application/xhtml+xml",
+		"application/rss+xml",
+		"application/atom+xml",
+		"application/manifest+json",
+		"application/wasm",
+		"image/svg+xml",
+		"image/x-icon",
+		"font/ttf",
+		"font/otf",
+	}
+)
+
+// Compression is the configuration of the responses' compression, the Q.Compression.
+// It's used by the Render and the templates when the Q.Gzip is true, by the StreamWriter and the SSE,
+// by the Dir{Gzip: true} and by the Compress middleware, which compresses the response of any handler
+type Compression struct {
+	// Encoders are the available encoders, the client's Accept-Encoding picks one of them by its q-values,
+	// the equal q-values are picked by the order of the Encoders.
+	// Defaults to the DefaultEncoders, "gzip" and "deflate"
+	Encoders []Encoder
+	// MinSize is the minimum bytes of a response to be compressed, the smaller responses are sent as they are,
+	// defaults to the DefaultCompressionMinSize, -1 to compress any response.
+	// The streams are compressed no matter their size
+	MinSize int
+	// ContentTypes are the content types which are compressed, 'type/*' matches with any subtype,
+	// defaults to the DefaultCompressionTypes
+	ContentTypes []string
+}
+
+// build sets the defaults of the Compression
+func (c *Compression) build() {
+	if len(c.Encoders) == 0 {
+		c.Encoders = DefaultEncoders
+	}
+	if c.MinSize == 0 {
+		c.MinSize = DefaultCompressionMinSize
+	}
+	if c.ContentTypes == nil {
+		c.ContentTypes = DefaultCompressionTypes
+	}
+}
+
+// allows returns true if a response of the content type and the size, -1 if unknown, should be compressed
+func (c *Compression) allows(ctype string, size int64) bool {
+	if size >= 0 && size < int64(c.MinSize) {
+		return false
+	}
+	if ctype == "" {
+		return false
+	}
+	return isAllowedContentType(strings.ToLower(ctype), c.ContentTypes)
+}
+
+// negotiate returns the Encoder which the Accept-Encoding prefers, nil if the client doesn't accept any of them
+func (c *Compression) negotiate(acceptEncoding string) Encoder {
+	names := make([]string, len(c.Encoders))
+	for i := range c.Encoders {
+		names[i] = c.Encoders[i].Name()
+	}
+	if idx := negotiateEncoding(acceptEncoding, names); idx != -1 {
+		return c.Encoders[idx]
+	}
+	return nil
+}
+
+// negotiateEncoding returns the index of the encoding which the Accept-Encoding prefers, by its q-values and then by the order of the encodings,
+// -1 if the client doesn't accept any of them, an empty Accept-Encoding accepts only the identity
+func negotiateEncoding(acceptEncoding string, encodings []string) int {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return -1
+	}
+	ranges := parseAccept(acceptEncoding)
+	best, bestQuality := -1, 0.0
+	for i, encoding := range encodings {
+		quality, found := 0.0, false
+		for _, ar := range ranges {
+			if ar.mediaType == encoding {
+				quality, found = ar.quality, true
+				break
+			}
+			if ar.mediaType == "*" {
+				quality = ar.quality
+			}
+		}
+		if !found && encoding == "gzip" {
+			// the legacy name
+			for _, ar := range ranges {
+				if ar.mediaType == "x-gzip" {
+					quality = ar.quality
+					break
+				}
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = i, quality
+		}
+	}
+	return best
+}
+
+// acquireEncoder returns the negotiated Encoder and its compressor which writes to the w, if the response of the content type and the size, -1 if unknown, should be compressed,
+// the Content-Encoding and the Vary headers are setted. Returns nil if the response is already encoded, it shouldn't be compressed or the client doesn't accept any of the encodings.
+// The caller releases the compressor
+func (ctx *Context) acquireEncoder(w http.ResponseWriter, ctype string, size int64) (Encoder, EncodeWriter) {
+	h := w.Header()
+	if h.Get(contentEncodingHeader) != "" || !ctx.q.Compression.allows(ctype, size) {
+		return nil, nil
+	}
+	h.Add(varyHeader, acceptEncodingHeader)
+	encoder := ctx.q.Compression.negotiate(ctx.RequestHeader(acceptEncodingHeader))
+	if encoder == nil {
+		return nil, nil
+	}
+	h.Set(contentEncodingHeader, encoder.Name())
+	h.Del(contentLength)
+	return encoder, encoder.Acquire(w)
+}
+
+// Compress is a middleware which compresses the response of the next handlers, of any handler, not only of the Render,
+// by the Encoder which the client's Accept-Encoding prefers, if its Content-Type is one of the Compression.ContentTypes
+// and it's not smaller than the Compression.MinSize.
+// The Content-Type is detected from the first bytes if it's not setted, the responses which are already encoded or partial are not compressed.
+// It can be a Request.Use, a Begin or an entry's middleware, the compression ends at the end of the request.
+//
+// Example:
+//  q.Request{Use: q.Handlers{q.Compress}}
+func Compress(ctx *Context) {
+	if ctx.compressor != nil {
+		return
+	}
+	cw := &compressWriter{ResponseWriter: ctx.ResponseWriter, ctx: ctx}
+	ctx.compressor = cw
+	ctx.ResponseWriter = cw
+}
+
+// compressWriter is the ResponseWriter of the Compress middleware, it keeps the status code and the first bytes, up to the Compression.MinSize,
+// until it knows if the response should be compressed
+type compressWriter struct {
+	ResponseWriter
+	ctx        *Context
+	statusCode int
+	buf        []byte
+	decided    bool
+	encoder    Encoder
+	writer     EncodeWriter
+}
+
+var _ ResponseWriter = &compressWriter{}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.decided {
+		if cw.writer == nil {
+			cw.ResponseWriter.WriteHeader(statusCode)
+		}
+		return
+	}
+	cw.statusCode = statusCode
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.ctx.q.Compression.MinSize {
+			return len(b), nil
+		}
+		if err := cw.decide(-1); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.writer != nil {
+		return cw.writer.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// decide compresses or not the response, sends the status code and the kept bytes, the size is the bytes of the whole response, -1 if unknown
+func (cw *compressWriter) decide(size int64) error {
+	cw.decided = true
+	h := cw.ResponseWriter.Header()
+	if h.Get(contentType) == "" && len(cw.buf) > 0 {
+		h.Set(contentType, http.DetectContentType(cw.buf))
+	}
+	statusCode := cw.StatusCode()
+	if statusCode >= StatusOK && statusCode != StatusNoContent && statusCode != StatusNotModified && statusCode != StatusPartialContent &&
+		h.Get(contentRangeHeader) == "" {
+		cw.encoder, cw.writer = cw.ctx.acquireEncoder(cw.ResponseWriter, h.Get(contentType), size)
+	}
+	if cw.statusCode != 0 {
+		cw.ResponseWriter.WriteHeader(cw.statusCode)
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.writer != nil {
+		_, err = cw.writer.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (cw *compressWriter) StatusCode() int {
+	if !cw.decided && cw.statusCode != 0 {
+		return cw.statusCode
+	}
+	return cw.ResponseWriter.StatusCode()
+}
+
+func (cw *compressWriter) Size() int {
+	return cw.ResponseWriter.Size() + len(cw.buf)
+}
+
+// SetBody replaces the buffered body, the compression starts over
+func (cw *compressWriter) SetBody(b []byte) {
+	if !cw.decided {
+		cw.buf = append(cw.buf[0:0], b...)
+		return
+	}
+	cw.ResponseWriter.SetBody(b)
+}
+
+// Flush sends the kept bytes, compressed or not, and the pending compressed data, the MinSize is not checked for a flushed response
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(-1)
+	}
+	if cw.writer != nil {
+		cw.writer.Flush()
+	}
+	cw.ResponseWriter.Flush()
+}
+
+// close ends the compression and restores the ResponseWriter, it's called at the end of the request
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		cw.decide(int64(len(cw.buf)))
+	}
+	if cw.writer != nil {
+		cw.encoder.Release(cw.writer)
+		cw.writer = nil
+	}
+	if cw.ctx.ResponseWriter == cw {
+		cw.ctx.ResponseWriter = cw.ResponseWriter
+	}
+}
@@ -0,0 +1,20 @@
+// Package brotli is the opt-in "br" q.Encoder, by the github.com/andybalholm/brotli, it's not one of the q.DefaultEncoders,
+// i.e q.Compression{Encoders: []q.Encoder{brotli.Encoder, q.GzipEncoder, q.DeflateEncoder}}
+package brotli
+
+import (
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/kataras/q"
+)
+
+// Encoder is the "br" q.Encoder of the default level
+var Encoder = New(brotli.DefaultCompression)
+
+// New returns the "br" q.Encoder of the level, 0 (BestSpeed) to 11 (BestCompression)
+func New(level int) q.Encoder {
+	return q.NewPooledEncoder("br", func(w io.Writer) q.ResetWriter {
+		return brotli.NewWriterLevel(w, level)
+	})
+}
@@ -0,0 +1,37 @@
+package brotli
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/kataras/q"
+)
+
+func TestEncoder(t *testing.T) {
+	big := strings.Repeat("hello compression ", 200)
+	app := (&q.Q{Host: "mydomain.com:80", DisableServer: true,
+		Compression: q.Compression{Encoders: []q.Encoder{Encoder, q.GzipEncoder}},
+		Request: q.Request{Use: q.Handlers{q.Compress}, Entries: q.Entries{
+			q.Entry{Method: q.MethodGet, Path: "/", Handler: func(ctx *q.Context) {
+				ctx.SetContentType("text/plain")
+				io.WriteString(ctx.ResponseWriter, big)
+			}},
+		}}}).Go()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip;q=0.5")
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "br" {
+		t.Fatalf("%v", rec.Header())
+	}
+	b, err := ioutil.ReadAll(brotli.NewReader(bytes.NewReader(rec.Body.Bytes())))
+	if string(b) != big {
+		t.Errorf("body %d %v", len(b), err)
+	}
+}
@@ -0,0 +1,22 @@
+// Package zstd is the opt-in "zstd" q.Encoder, by the github.com/klauspost/compress/zstd, it's not one of the q.DefaultEncoders,
+// i.e q.Compression{Encoders: []q.Encoder{zstd.Encoder, q.GzipEncoder, q.DeflateEncoder}}
+package zstd
+
+import (
+	"io"
+
+	"github.com/kataras/q"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encoder is the "zstd" q.Encoder of the default level
+var Encoder = New(3)
+
+// New returns the "zstd" q.Encoder of the level, 1 (fastest) to 22 (best compression)
+func New(level int) q.Encoder {
+	return q.NewPooledEncoder("zstd", func(w io.Writer) q.ResetWriter {
+		// the options are valid, the error can be ignored
+		writer, _ := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderConcurrency(1))
+		return writer
+	})
+}
@@ -0,0 +1,41 @@
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kataras/q"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestEncoder(t *testing.T) {
+	big := strings.Repeat("hello compression ", 200)
+	app := (&q.Q{Host: "mydomain.com:80", DisableServer: true,
+		Compression: q.Compression{Encoders: []q.Encoder{Encoder, q.GzipEncoder}},
+		Request: q.Request{Use: q.Handlers{q.Compress}, Entries: q.Entries{
+			q.Entry{Method: q.MethodGet, Path: "/", Handler: func(ctx *q.Context) {
+				ctx.SetContentType("text/plain")
+				io.WriteString(ctx.ResponseWriter, big)
+			}},
+		}}}).Go()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "zstd, gzip;q=0.5")
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "zstd" {
+		t.Fatalf("%v", rec.Header())
+	}
+	zr, err := zstd.NewReader(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if string(b) != big {
+		t.Errorf("body %d %v", len(b), err)
+	}
+}
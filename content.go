@@ -0,0 +1,303 @@
+package q
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kataras/q/errors"
+)
+
+const (
+	// etagHeader represents the header "ETag"
+	etagHeader = "ETag"
+	// ifNoneMatch represents the header "If-None-Match"
+	ifNoneMatch = "If-None-Match"
+	// ifMatch represents the header "If-Match"
+	ifMatch = "If-Match"
+	// ifUnmodifiedSince represents the header "If-Unmodified-Since"
+	ifUnmodifiedSince = "If-Unmodified-Since"
+	// ifRange represents the header "If-Range"
+	ifRange = "If-Range"
+	// rangeHeader represents the header "Range"
+	rangeHeader = "Range"
+	// acceptRangesHeader represents the header "Accept-Ranges"
+	acceptRangesHeader = "Accept-Ranges"
+	// contentRangeHeader represents the header "Content-Range"
+	contentRangeHeader = "Content-Range"
+)
+
+var (
+	errRangeInvalid        = errors.New("Invalid range: '%s'")
+	errRangeNotSatisfiable = errors.New("Range not satisfiable: '%s'")
+)
+
+// ContentETag returns a strong ETag of the content, its hash, it's used by the in-memory static handlers, i.e the File and the Favicon
+func ContentETag(content []byte) string {
+	sum := sha1.Sum(content)
+	return `"` + hex.EncodeToString(sum[0:10]) + `"`
+}
+
+// FileETag returns a weak ETag of a file, from its size and its modification time, it's used by the ServeContent and the Dir
+func FileETag(size int64, modtime time.Time) string {
+	return fmt.Sprintf(`W/"%x-%x"`, modtime.UnixNano(), size)
+}
+
+// serveContent serves the content with the conditional requests and the ranges support, it's used by the ServeContent, the Dir, the File and the Favicon.
+//
+// The If-Match, If-Unmodified-Since, If-None-Match and If-Modified-Since are checked by the RFC 7232, in that order,
+// the Range, one or more byte ranges, is served if the If-Range, if any, matches with the etag or the modtime,
+// the content is compressed, by the negotiated encoder of the Q.Compression, only when it's served whole.
+func (ctx *Context) serveContent(content io.ReadSeeker, size int64, ctype string, modtime time.Time, etag string, compress bool) error {
+	h := ctx.ResponseWriter.Header()
+	if etag != "" {
+		h.Set(etagHeader, etag)
+	} else {
+		// setted by the caller
+		etag = h.Get(etagHeader)
+	}
+	if !isZeroTime(modtime) {
+		h.Set(lastModified, modtime.UTC().Format(ctx.q.TimeFormat))
+	}
+
+	if statusCode := ctx.checkPreconditions(etag, modtime); statusCode != 0 {
+		if statusCode == StatusNotModified {
+			h.Del(contentType)
+			h.Del(contentLength)
+		}
+		ctx.ResponseWriter.WriteHeader(statusCode)
+		return nil
+	}
+
+	if ctype != "" && h.Get(contentType) == "" {
+		h.Set(contentType, ctype)
+	}
+	h.Set(acceptRangesHeader, "bytes")
+
+	var ranges []byteRange
+	if rangeValue := ctx.RequestHeader(rangeHeader); rangeValue != "" && ctx.Request.Method == MethodGet && ctx.checkIfRange(etag, modtime) {
+		var err error
+		if ranges, err = parseRange(rangeValue, size); err != nil {
+			h.Set(contentRangeHeader, "bytes */"+strconv.FormatInt(size, 10))
+			ctx.ResponseWriter.WriteHeader(StatusRequestedRangeNotSatisfiable)
+			return nil
+		}
+		if sumRanges(ranges) > size {
+			// too many or overlapping ranges, serve the whole content
+			ranges = nil
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		if compress {
+			if encoder, w := ctx.acquireEncoder(ctx.ResponseWriter, h.Get(contentType), size); encoder != nil {
+				ctx.ResponseWriter.WriteHeader(StatusOK)
+				_, err := io.CopyN(w, content, size)
+				if rerr := encoder.Release(w); err == nil {
+					err = rerr
+				}
+				return errServeContent.With(err)
+			}
+		}
+		h.Set(contentLength, strconv.FormatInt(size, 10))
+		ctx.ResponseWriter.WriteHeader(StatusOK)
+		_, err := io.CopyN(ctx.ResponseWriter, content, size)
+		return errServeContent.With(err)
+	case 1:
+		r := ranges[0]
+		if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+			return errServeContent.With(err)
+		}
+		h.Set(contentRangeHeader, r.contentRange(size))
+		h.Set(contentLength, strconv.FormatInt(r.length, 10))
+		ctx.ResponseWriter.WriteHeader(StatusPartialContent)
+		_, err := io.CopyN(ctx.ResponseWriter, content, r.length)
+		return errServeContent.With(err)
+	}
+
+	// multipart/byteranges
+	mw := multipart.NewWriter(ctx.ResponseWriter)
+	partType := h.Get(contentType)
+	h.Set(contentType, "multipart/byteranges; boundary="+mw.Boundary())
+	h.Del(contentLength)
+	ctx.ResponseWriter.WriteHeader(StatusPartialContent)
+	for _, r := range ranges {
+		header := textproto.MIMEHeader{contentRangeHeader: {r.contentRange(size)}}
+		if partType != "" {
+			header.Set(contentType, partType)
+		}
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return errServeContent.With(err)
+		}
+		if _, err = content.Seek(r.start, io.SeekStart); err != nil {
+			return errServeContent.With(err)
+		}
+		if _, err = io.CopyN(part, content, r.length); err != nil {
+			return errServeContent.With(err)
+		}
+	}
+	return errServeContent.With(mw.Close())
+}
+
+// checkPreconditions returns the status code of a failed precondition, StatusPreconditionFailed or StatusNotModified, 0 if the content should be served
+func (ctx *Context) checkPreconditions(etag string, modtime time.Time) int {
+	isGetOrHead := ctx.Request.Method == MethodGet || ctx.Request.Method == MethodHead
+	modtime = modtime.Truncate(time.Second)
+
+	if match := ctx.RequestHeader(ifMatch); match != "" {
+		if !etagMatch(match, etag, true) {
+			return StatusPreconditionFailed
+		}
+	} else if since := ctx.RequestHeader(ifUnmodifiedSince); since != "" && !isZeroTime(modtime) {
+		if t, err := http.ParseTime(since); err == nil && modtime.After(t) {
+			return StatusPreconditionFailed
+		}
+	}
+
+	if noneMatch := ctx.RequestHeader(ifNoneMatch); noneMatch != "" {
+		if etagMatch(noneMatch, etag, false) {
+			if isGetOrHead {
+				return StatusNotModified
+			}
+			return StatusPreconditionFailed
+		}
+	} else if since := ctx.RequestHeader(ifModifiedSince); since != "" && isGetOrHead && !isZeroTime(modtime) {
+		if t, err := http.ParseTime(since); err == nil && !modtime.After(t) {
+			return StatusNotModified
+		}
+	}
+	return 0
+}
+
+// checkIfRange returns true if the Range should be served, the If-Range is missing or it matches with the etag, strongly, or the modtime
+func (ctx *Context) checkIfRange(etag string, modtime time.Time) bool {
+	value := ctx.RequestHeader(ifRange)
+	if value == "" {
+		return true
+	}
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		return etagMatch(value, etag, true)
+	}
+	t, err := http.ParseTime(value)
+	return err == nil && !isZeroTime(modtime) && modtime.Truncate(time.Second).Equal(t)
+}
+
+// etagMatch returns true if one of the header's ETags, or the '*', matches with the etag,
+// the strong comparison doesn't match the weak ETags
+func etagMatch(header string, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong {
+			if candidate == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isZeroTime returns true if the time is unknown
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Equal(time.Unix(0, 0))
+}
+
+// byteRange is a range of the Range header
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange returns the byte ranges of a Range header, i.e 'bytes=0-499,-500',
+// the ranges which start after the content's size are ignored, returns an error if the header is invalid or none range is satisfiable
+func parseRange(value string, size int64) ([]byteRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(value, prefix) {
+		return nil, errRangeInvalid.Format(value)
+	}
+	var ranges []byteRange
+	for _, spec := range strings.Split(value[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		idx := strings.IndexByte(spec, '-')
+		if idx == -1 {
+			return nil, errRangeInvalid.Format(value)
+		}
+		start, end := strings.TrimSpace(spec[0:idx]), strings.TrimSpace(spec[idx+1:])
+		var r byteRange
+		if start == "" {
+			// the last n bytes
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errRangeInvalid.Format(value)
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r.start, r.length = size-n, n
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, errRangeInvalid.Format(value)
+			}
+			if i >= size {
+				continue
+			}
+			r.start = i
+			if end == "" {
+				r.length = size - i
+			} else {
+				j, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || i > j {
+					return nil, errRangeInvalid.Format(value)
+				}
+				if j >= size {
+					j = size - 1
+				}
+				r.length = j - i + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, errRangeNotSatisfiable.Format(value)
+	}
+	return ranges, nil
+}
+
+// sumRanges returns the total length of the ranges
+func sumRanges(ranges []byteRange) (sum int64) {
+	for _, r := range ranges {
+		sum += r.length
+	}
+	return
+}
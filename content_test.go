@@ -0,0 +1,90 @@
+package q
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// staticDir returns a temporary directory of the files, the func removes it
+func staticDir(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "q-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestContentConditional(t *testing.T) {
+	dir, remove := staticDir(t, map[string]string{"big.txt": strings.Repeat("0123456789", 400)})
+	defer remove()
+	qq := newQ(Entries{
+		Entry{Path: "/file.txt", Method: "GET", Parser: File{ContentType: "text/plain", Content: []byte("0123456789")}},
+		Entry{Path: "/static", Method: "GET", Parser: Dir{Directory: dir}},
+		Entry{Path: "/gz", Method: "GET", Parser: Dir{Directory: dir, Gzip: true}},
+	})
+	rec := serve(qq, "GET", "/file.txt")
+	etag, lm := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if rec.Code != 200 || rec.Body.String() != "0123456789" || !strings.HasPrefix(etag, `"`) || rec.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("full %d %q %q", rec.Code, rec.Body.String(), etag)
+	}
+	rec = serve(qq, "GET", "/static/big.txt")
+	detag := rec.Header().Get("ETag")
+	if rec.Code != 200 || rec.Body.Len() != 4000 || !strings.HasPrefix(detag, `W/"`) {
+		t.Fatalf("dir %d %d %q", rec.Code, rec.Body.Len(), detag)
+	}
+	cases := []struct {
+		path    string
+		headers []string
+		code    int
+		// the body, or its size when it's empty, -1 doesn't check it
+		body string
+		size int
+		// the expected response headers, pairs of a key and a value
+		want []string
+	}{
+		{"/file.txt", []string{"If-None-Match", `"x", ` + etag}, 304, "", 0, nil},
+		{"/file.txt", []string{"If-None-Match", "W/" + etag}, 304, "", 0, nil},
+		{"/file.txt", []string{"If-Match", `"nope"`}, 412, "", -1, nil},
+		{"/file.txt", []string{"If-Modified-Since", lm}, 304, "", 0, nil},
+		{"/file.txt", []string{"If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT"}, 200, "0123456789", 0, nil},
+		{"/file.txt", []string{"Range", "bytes=2-4"}, 206, "234", 0, []string{"Content-Range", "bytes 2-4/10", "Content-Length", "3"}},
+		{"/file.txt", []string{"Range", "bytes=-3"}, 206, "789", 0, nil},
+		{"/file.txt", []string{"Range", "bytes=20-"}, 416, "", -1, []string{"Content-Range", "bytes */10"}},
+		{"/file.txt", []string{"Range", "bytes=2-4", "If-Range", `"old"`}, 200, "0123456789", 0, nil},
+		{"/file.txt", []string{"Range", "bytes=2-4", "If-Range", etag}, 206, "234", 0, nil},
+		{"/static/big.txt", []string{"If-None-Match", detag}, 304, "", 0, nil},
+		{"/static/big.txt", []string{"Range", "bytes=100-199"}, 206, "", 100, nil},
+		{"/gz/big.txt", []string{"Accept-Encoding", "gzip"}, 200, "", -1, []string{"Content-Encoding", "gzip"}},
+		{"/gz/big.txt", []string{"Accept-Encoding", "gzip", "Range", "bytes=0-9"}, 206, "", 10, []string{"Content-Encoding", ""}},
+	}
+	for _, c := range cases {
+		rec := serve(qq, "GET", c.path, c.headers...)
+		ok := rec.Code == c.code
+		if c.body != "" {
+			ok = ok && rec.Body.String() == c.body
+		} else if c.size >= 0 {
+			ok = ok && rec.Body.Len() == c.size
+		}
+		for i := 0; i+1 < len(c.want); i += 2 {
+			ok = ok && rec.Header().Get(c.want[i]) == c.want[i+1]
+		}
+		if !ok {
+			t.Errorf("%s %v: got %d %q %v", c.path, c.headers, rec.Code, rec.Body.String(), rec.Header())
+		}
+	}
+
+	rec = serve(qq, "GET", "/file.txt", "Range", "bytes=0-1,5-6")
+	ct := rec.Header().Get("Content-Type")
+	if rec.Code != 206 || !strings.HasPrefix(ct, "multipart/byteranges; boundary=") || !strings.Contains(rec.Body.String(), "Content-Range: bytes 5-6/10") || !strings.Contains(rec.Body.String(), "\r\n\r\n56\r\n") {
+		t.Errorf("multi %d %q %q", rec.Code, ct, rec.Body.String())
+	}
+}
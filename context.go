@@ -35,6 +35,10 @@ const (
 	varyHeader = "Vary"
 	// acceptEncodingHeader represents the header key & value "Accept-Encoding"
 	acceptEncodingHeader = "Accept-Encoding"
+	// acceptHeader represents the header "Accept"
+	acceptHeader = "Accept"
+	// allowHeader represents the header "Allow", sent with the 405 Method Not Allowed and the automatic OPTIONS responses
+	allowHeader = "Allow"
 	// ContentHTML is the  string of text/html response headers
 	contentHTML = "text/html"
 	// ContentBinary header value for binary data.
@@ -65,7 +69,8 @@ const (
 	flashMessageCookiePrefix     = "_q_flash_message_"
 	cookieHeaderID               = "Cookie: "
 	cookieHeaderIDLen            = len(cookieHeaderID)
-	httpStatusCodeContextKey     = "_q_http_status_code_"
+	// used inside Q.ServeHTTP to store the recovered value of a panic, look .Recovered
+	panicContextKey = "_q_panic_"
 )
 
 // errors
@@ -147,7 +152,8 @@ type (
 
 	// Context the bridge between q's functionality and a client's request
 	Context struct {
-		ResponseWriter http.ResponseWriter
+		// ResponseWriter keeps the status code and the written body's size, it can buffer the response, look writer.go
+		ResponseWriter ResponseWriter
 		Request        *http.Request
 		values         requestValues
 		Params         PathParameters
@@ -157,6 +163,20 @@ type (
 		session  *sessionStore
 		// pos is the position number of the Context, look .Serve & .Cancel to understand
 		pos int
+		// errors are the custom http errors of the request's full host, if any, look .EmitError
+		errors Errors
+		// usePos is the position of the next Request.Use middleware, look .Next
+		usePos int
+		// entry is the route which serves the request, if any, look .Entry
+		entry *route
+		// writer is the default ResponseWriter, it's pooled with the Context
+		writer responseWriter
+		// sse is the Server-Sent Events stream of the request, if any, look .SSE
+		sse *SSE
+		// compressor is the ResponseWriter of the Compress middleware, if any
+		compressor *compressWriter
+		// tempFiles are the temp files of the request's uploads, they are removed at the end of the request, look .Uploads
+		tempFiles []string
 	}
 )
 
@@ -179,6 +199,42 @@ func (ctx *Context) ForceNext() {
 	}
 }
 
+// Next runs the rest Request.Use middleware and the router, the caller continues its execution after them,
+// it's used by the Request.Use middleware, the router is called once, so the Next does nothing if it's called by an entry's handler
+func (ctx *Context) Next() {
+	use := ctx.q.Request.Use
+	for ctx.usePos < len(use) {
+		h := use[ctx.usePos]
+		ctx.usePos++
+		h(ctx)
+		if ctx.IsStopped() {
+			// stopped before the routing or the router has finished
+			ctx.usePos = len(use) + 1
+			return
+		}
+	}
+
+	if ctx.usePos == len(use) && ctx.q.Request.router != nil {
+		ctx.usePos++
+		ctx.q.Request.router(ctx)
+	}
+}
+
+// Entry returns the CompiledEntry which serves the request, nil if the request is not served by an entry, i.e 404 and 405.
+// It's useful for the Request.Use middleware, after the ctx.Next
+func (ctx *Context) Entry() CompiledEntry {
+	if ctx.entry == nil {
+		return nil
+	}
+	return ctx.entry
+}
+
+// Recovered returns the recovered value of a panic in the request's handlers, nil if no panic,
+// it's useful inside the StatusInternalServerError's error handler
+func (ctx *Context) Recovered() interface{} {
+	return ctx.Get(panicContextKey)
+}
+
 // StopExecution just sets the .pos to 255 in order to  not move to the next middlewares(if any)
 func (ctx *Context) StopExecution() {
 	ctx.pos = stopExecutionPosition
@@ -210,13 +266,15 @@ func (ctx *Context) Q() *Q {
 /*
   Compatibility with standard Golang's Context
 	Implement the interface: https://github.com/golang/net/blob/master/context/context.go#L45
+	The Deadline, Done and Err are the Request.Context()'s, it's canceled when the client's connection closes,
+	the server shuts down or the Entry.Timeout is passed
 */
 
 // Deadline returns the time when work done on behalf of this context
 // should be canceled.  Deadline returns ok==false when no deadline is
 // set.  Successive calls to Deadline return the same results.
 func (ctx *Context) Deadline() (deadline time.Time, ok bool) {
-	return
+	return ctx.Request.Context().Deadline()
 }
 
 // Done returns a channel that's closed when work done on behalf of this
@@ -249,7 +307,7 @@ func (ctx *Context) Deadline() (deadline time.Time, ok bool) {
 // See http://blog.golang.org/pipelines for more examples of how to use
 // a Done channel for cancelation.
 func (ctx *Context) Done() <-chan struct{} {
-	return nil
+	return ctx.Request.Context().Done()
 }
 
 // Err returns a non-nil error value after Done is closed.  Err returns
@@ -257,7 +315,7 @@ func (ctx *Context) Done() <-chan struct{} {
 // context's deadline passed.  No other values for Err are defined.
 // After Done is closed, successive calls to Err return the same value.
 func (ctx *Context) Err() error {
-	return nil
+	return ctx.Request.Context().Err()
 }
 
 // Value returns the value associated with this context for key, or nil
@@ -310,11 +368,14 @@ func (ctx *Context) Value(key interface{}) interface{} {
 		return ctx.Request
 	}
 
-	// we support only string keys, so if not a string then return nil
+	// the string keys are the request values, look .Get/.Set
 	if k, ok := key.(string); ok {
-		return ctx.Get(k)
+		if v := ctx.Get(k); v != nil {
+			return v
+		}
 	}
-	return nil
+	// the values of the Request.Context(), i.e setted by a net/http middleware
+	return ctx.Request.Context().Value(key)
 }
 
 /* Request */
@@ -367,7 +428,7 @@ func (ctx *Context) RequestPath(escape bool) string {
 	return ctx.Request.URL.Path
 }
 
-// RequestIP gets just the Remote Address from the client.
+// RequestIP gets just the Remote Address from the client, the peer, which can be a proxy, look .RemoteAddr
 func (ctx *Context) RequestIP() string {
 	if ip, _, err := net.SplitHostPort(strings.TrimSpace(ctx.Request.RemoteAddr)); err == nil {
 		return ip
@@ -375,25 +436,6 @@ func (ctx *Context) RequestIP() string {
 	return ""
 }
 
-// RemoteAddr is like RequestIP but it checks for proxy servers also, tries to get the real client's request IP
-func (ctx *Context) RemoteAddr() string {
-	header := ctx.RequestHeader("X-Real-Ip")
-	realIP := strings.TrimSpace(header)
-	if realIP != "" {
-		return realIP
-	}
-	realIP = ctx.RequestHeader("X-Forwarded-For")
-	idx := strings.IndexByte(realIP, ',')
-	if idx >= 0 {
-		realIP = realIP[0:idx]
-	}
-	realIP = strings.TrimSpace(realIP)
-	if realIP != "" {
-		return realIP
-	}
-	return ctx.RequestIP()
-}
-
 // RequestHeader returns the request header's value
 // accepts one parameter, the key of the header (string)
 // returns string
@@ -409,6 +451,7 @@ func (ctx *Context) FormValues(key string) []string {
 }
 
 // Subdomain returns the subdomain (string) of this request, if any
+// it's the first part of the request's host, i.e 'eu' for the 'eu.tenant.mydomain.com', look the ctx.Subdomains for all of its levels
 func (ctx *Context) Subdomain() (subdomain string) {
 	host := ctx.Request.Host
 	if index := strings.IndexByte(host, '.'); index > 0 {
@@ -418,20 +461,28 @@ func (ctx *Context) Subdomain() (subdomain string) {
 	return
 }
 
+// Subdomains returns all levels of the subdomain of this request, if the request's host is a subdomain of the Q.Host,
+// i.e 'eu.tenant' for the 'eu.tenant.mydomain.com', otherwise it's the same as the ctx.Subdomain
+// look the ctx.Param for the subdomain's named parameters, i.e ':tenant.'
+func (ctx *Context) Subdomains() string {
+	host := parseHostname(ctx.Request.Host)
+	if domain := parseHostname(ctx.q.Host); strings.HasSuffix(host, "."+domain) {
+		return host[0 : len(host)-len(domain)-1]
+	} else if host == domain {
+		return ""
+	}
+	return ctx.Subdomain()
+}
+
 // Body reads & returns all request's body contents
 func (ctx *Context) Body() ([]byte, error) {
 	return ioutil.ReadAll(ctx.Request.Body)
 }
 
 // ReadJSON reads JSON from request's body
+// look .Bind also
 func (ctx *Context) ReadJSON(jsonObject interface{}) error {
-	b, err := ctx.Body()
-	if err != nil && err != io.EOF {
-		return err
-	}
-	decoder := json.NewDecoder(strings.NewReader(string(b)))
-	err = decoder.Decode(jsonObject)
-
+	err := json.NewDecoder(ctx.Request.Body).Decode(jsonObject)
 	if err != nil && err != io.EOF {
 		return errReadBody.Format("JSON", err.Error())
 	}
@@ -439,13 +490,9 @@ func (ctx *Context) ReadJSON(jsonObject interface{}) error {
 }
 
 // ReadXML reads XML from request's body
+// look .Bind also
 func (ctx *Context) ReadXML(xmlObject interface{}) error {
-	b, err := ctx.Body()
-	if err != nil && err != io.EOF {
-		return err
-	}
-	decoder := xml.NewDecoder(strings.NewReader(string(b)))
-	err = decoder.Decode(xmlObject)
+	err := xml.NewDecoder(ctx.Request.Body).Decode(xmlObject)
 	if err != nil && err != io.EOF {
 		return errReadBody.Format("XML", err.Error())
 	}
@@ -454,7 +501,7 @@ func (ctx *Context) ReadXML(xmlObject interface{}) error {
 }
 
 // ReadForm binds the formObject  with the form data
-// it supports any kind of struct
+// it supports any kind of struct, look .Bind for the path parameters, the query and the headers also
 func (ctx *Context) ReadForm(formObject interface{}) error {
 	err := ctx.Request.ParseForm()
 	if err != nil {
@@ -485,22 +532,15 @@ func (ctx *Context) ReadForm(formObject interface{}) error {
 /* Response */
 
 // SetStatusCode sets the status code via ResponseWriter.WriteHeader
-// we could make a custom ResponseWriter to hold the status code, but, keep things simple to the end-user
+// the status code can't be changed after it's written, unless the response is buffered, look ResponseWriter.Buffer
 func (ctx *Context) SetStatusCode(code int) {
-	prevCode := ctx.GetInt(httpStatusCodeContextKey)
-	if prevCode == -1 { // if not setted before using SetStatusCode then set it.
-		ctx.Set(httpStatusCodeContextKey, code)
-		ctx.ResponseWriter.WriteHeader(code)
-	}
+	ctx.ResponseWriter.WriteHeader(code)
 }
 
 // StatusCode returns the http status code, if no status code was written yet, then it returns 200
-// works only when you setted the status code via context.SetStatusCode.
+// it's the ResponseWriter's, so it works for the ResponseWriter.WriteHeader also
 func (ctx *Context) StatusCode() int {
-	if statusCode := ctx.GetInt(httpStatusCodeContextKey); statusCode > 0 {
-		return statusCode
-	}
-	return StatusOK
+	return ctx.ResponseWriter.StatusCode()
 }
 
 // SetContentType sets the response writer's header key 'Content-Type' to a given value(s)
@@ -573,7 +613,10 @@ func (ctx *Context) Panic() {
 
 // EmitError executes the custom error by the http status code passed to the function
 func (ctx *Context) EmitError(statusCode int) {
-	errHandler := ctx.q.Request.Errors[statusCode] //one reader, no need to lock this
+	errHandler := ctx.errors[statusCode] // the full host's errors, if any
+	if errHandler == nil {
+		errHandler = ctx.q.Request.errorHandlers()[statusCode] // published atomically, they can be changed at runtime
+	}
 	if errHandler != nil {
 		errHandler(ctx)
 	}
@@ -594,26 +637,13 @@ func (ctx *Context) WriteString(format string, a ...interface{}) {
 	io.WriteString(ctx.ResponseWriter, fmt.Sprintf(format, a...))
 }
 
-func (ctx *Context) clientAllowsGzip() bool {
-	if h := ctx.RequestHeader(acceptEncodingHeader); h != "" {
-		for _, v := range strings.Split(h, ";") {
-			if strings.Contains(v, "gzip") { // we do Contains because sometimes browsers has the q=, we don't use it atm. || strings.Contains(v,"deflate"){
-				return true
-			}
-		}
-	}
-
-	return false
-}
-
 // WriteGzip accepts bytes, which will be compressed using gzip compression and will be sent to the client
+// the headers are setted before the write, use the Compress middleware or the Q.Gzip to compress by the encoder which the client prefers
 func (ctx *Context) WriteGzip(b []byte) (int, error) {
 	ctx.ResponseWriter.Header().Add(varyHeader, acceptEncodingHeader)
-	n, err := WriteGzip(ctx.ResponseWriter, b)
-	if err == nil {
-		ctx.SetHeader("Content-Encoding", "gzip")
-	}
-	return n, err
+	ctx.SetHeader(contentEncodingHeader, "gzip")
+	ctx.ResponseWriter.Header().Del(contentLength)
+	return WriteGzip(ctx.ResponseWriter, b)
 }
 
 // Render if no http status code was written before, the StatusOK(200) will be sent
@@ -670,6 +700,22 @@ func (ctx *Context) XML(v interface{}) error {
 	return ctx.Render(contentXML, v)
 }
 
+// Negotiate renders the obj by the response engine which matches best with the request's Accept header, its q-values are respected,
+// when the client accepts more than one engine equally then the defaults, JSON, XML, JSONP, markdown, text and binary, in that order, are preferred over the user-defined.
+// The markdown and the text engines are negotiated only for a string obj and the binary only for a []byte obj.
+// The 'Vary: Accept' header is setted, if no engine matches then the StatusNotAcceptable(406)'s error handler is emitted and an error is returned.
+// Note: the options: "gzip" and "charset" are built'n support, like the .Render
+func (ctx *Context) Negotiate(obj interface{}, options ...map[string]interface{}) error {
+	ctx.ResponseWriter.Header().Add(varyHeader, acceptHeader)
+	accept := ctx.RequestHeader(acceptHeader)
+	engineMap := ctx.q.responses.negotiate(accept, obj)
+	if engineMap == nil {
+		ctx.EmitError(StatusNotAcceptable)
+		return errNotAcceptable.Format(accept)
+	}
+	return engineMap.render(ctx, obj, options...)
+}
+
 // MarkdownString parses the (dynamic) markdown string and returns the converted html string
 func (ctx *Context) MarkdownString(markdownText string) string {
 	return ctx.q.ResponseString(contentMarkdown, markdownText)
@@ -684,33 +730,25 @@ func (ctx *Context) Markdown(markdown string) {
 // ServeContent serves content, headers are autoset
 // receives four parameters, it's low-level function, instead you can use .ServeFile(string)
 //
-// You can define your own "Content-Type" header also, after this function call
+// The conditional requests (If-None-Match, If-Modified-Since, If-Match, If-Unmodified-Since) and the byte ranges (Range, If-Range) are supported,
+// the ETag is a weak one of the content's size and the modtime, unless an "ETag" header is setted before this function call.
+// The gzipCompression compresses the content by the encoder which the client prefers, look the Q.Compression.
+//
+// You can define your own "Content-Type" header also, before this function call
 func (ctx *Context) ServeContent(content io.ReadSeeker, filename string, modtime time.Time, gzipCompression bool) error {
-	h := ctx.ResponseWriter.Header()
-	if t, err := time.Parse(ctx.q.TimeFormat, ctx.RequestHeader(ifModifiedSince)); err == nil && modtime.Before(t.Add(1*time.Second)) {
-		h.Del(contentType)
-		h.Del(contentLength)
-		ctx.ResponseWriter.WriteHeader(StatusNotModified)
-		return nil
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errServeContent.With(err)
 	}
-
-	h.Set(contentType, typeByExtension(filename))
-	h.Set(lastModified, modtime.UTC().Format(ctx.q.TimeFormat))
-
-	var out io.Writer
-	if gzipCompression && ctx.clientAllowsGzip() {
-		h.Add(varyHeader, acceptEncodingHeader)
-		h.Set(contentEncodingHeader, "gzip")
-		gzipWriter := AcquireGzip(ctx.ResponseWriter)
-		defer ReleaseGzip(gzipWriter)
-		out = gzipWriter
-	} else {
-		out = ctx.ResponseWriter
-
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return errServeContent.With(err)
 	}
-	_, err := io.Copy(out, content)
 
-	return errServeContent.With(err)
+	etag := ""
+	if ctx.ResponseWriter.Header().Get(etagHeader) == "" {
+		etag = FileETag(size, modtime)
+	}
+	return ctx.serveContent(content, size, typeByExtension(filename), modtime, etag, gzipCompression)
 }
 
 // ServeFile serves a view file, to send a file ( zip for example) to the client with other filename
@@ -743,6 +781,7 @@ func (ctx *Context) ServeFileContent(filename string, gzipCompression bool) erro
 		if err != nil {
 			return fmt.Errorf("%d", 404)
 		}
+		defer f.Close()
 		fi, _ = f.Stat()
 	}
 	return ctx.ServeContent(f, fi.Name(), fi.ModTime(), gzipCompression)
@@ -883,11 +922,17 @@ func (ctx *Context) GetFlashes() map[string]string {
 
 func (ctx *Context) decodeFlashCookie(name string) (string, string) {
 	cookieName := flashMessageCookiePrefix + name
-	cookie, err := ctx.Request.Cookie(name)
+	cookie, err := ctx.Request.Cookie(cookieName)
 	if err != nil {
 		return "", ""
 	}
-	cookieValue, err := decodeCookieValue(cookie.Value)
+	value, err := ctx.q.cookies.decode(cookieName, cookie.Value)
+	if err != nil {
+		// forged or tampered, ignore it
+		ctx.q.Session.removeCookie(ctx, cookieName)
+		return "", ""
+	}
+	cookieValue, err := decodeCookieValue(value)
 	if err != nil {
 		return "", ""
 	}
@@ -932,22 +977,25 @@ func (ctx *Context) GetFlash(key string) (string, error) {
 	}
 
 	//remove the real cookie, no need to have that, we stored it on lifetime request
-	ctx.RemoveCookie(cookieName)
+	ctx.q.Session.removeCookie(ctx, cookieName)
 	return cookieValue, nil
 	//it should'b be removed until the next reload, so we don't do that: ctx.Request.Header.SetCookie(key, "")
 
 }
 
 // SetFlash sets a flash message, accepts 2 parameters the name(string) and the value(string)
-// the value will be available on the NEXT request
+// the value will be available on the NEXT request, it's signed or encrypted by the Session.Keys, if any,
+// the cookie has the Path, the Domain, the Secure and the SameSite of the Session
 func (ctx *Context) SetFlash(name string, value string) {
-	c := AcquireCookie()
-	//c := &http.Cookie{}
-	c.Name = flashMessageCookiePrefix + name
-	c.Value = encodeCookieValue(value)
-	c.Path = "/"
-	c.HttpOnly = true
-	ctx.AddCookie(c)
+	cookieName := flashMessageCookiePrefix + name
+	cookieValue, err := ctx.q.cookies.encode(cookieName, encodeCookieValue(value))
+	if err != nil {
+		ctx.q.Logger.Printf("%s\n", err)
+		return
+	}
+	// the same Path, Domain, Secure and SameSite as the session cookie
+	c := ctx.q.Session.acquireCookie(ctx, cookieName, cookieValue)
+	ctx.q.Session.addCookie(ctx, c)
 	ReleaseCookie(c)
 }
 
@@ -966,11 +1014,19 @@ func (ctx *Context) Session() SessionStore {
 // SessionDestroy destroys the whole session, calls the provider's destroy and remove the cookie
 func (ctx *Context) SessionDestroy() {
 	if sess := ctx.Session(); sess != nil {
-		ctx.q.sessions.destroy(ctx)
+		ctx.q.sessions.destroy(ctx, ctx.session)
 	}
 
 }
 
+// SessionRegenerate moves the session's values to a new session id and reissues the cookie, the old id is destroyed,
+// call it after the user's login, or any change of its privileges, in order to prevent the session fixation
+func (ctx *Context) SessionRegenerate() {
+	if sess := ctx.Session(); sess != nil {
+		ctx.q.sessions.regenerate(ctx, ctx.session)
+	}
+}
+
 // Log calls Printf to print to the logger.
 // Arguments are handled in the manner of fmt.Printf.
 func (ctx *Context) Log(format string, v ...interface{}) {
@@ -23,6 +23,8 @@ var (
 var cookiePool sync.Pool
 
 // AcquireCookie returns an empty Cookie object from the pool.
+// Its MaxAge is 0, no Max-Age attribute, previously it was -1, the 'Max-Age=0' which deletes the cookie,
+// set the MaxAge to -1 explicitly in order to delete a cookie.
 //
 // The returned object may be returned back to the pool with ReleaseCookie.
 // This allows reducing GC load.
@@ -40,7 +42,8 @@ func AcquireCookie() *http.Cookie {
 	cookie.Raw = ""
 	cookie.Value = ""
 	cookie.Domain = ""
-	cookie.MaxAge = -1
+	cookie.MaxAge = 0
+	cookie.Secure = false
 	cookie.Expires = CookieExpireUnlimited
 	return cookie
 }
@@ -0,0 +1,15 @@
+package q
+
+import "testing"
+
+func TestAcquireCookie(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		cookie := AcquireCookie()
+		if cookie.MaxAge != 0 || cookie.Secure || cookie.HttpOnly || cookie.Domain != "" || cookie.Path != "" || cookie.Name != "" {
+			t.Fatalf("the cookie is not reset %#v", cookie)
+		}
+		cookie.Name, cookie.Domain, cookie.Path = "name", ".mydomain.com", "/"
+		cookie.MaxAge, cookie.Secure, cookie.HttpOnly = -1, true, true
+		ReleaseCookie(cookie)
+	}
+}
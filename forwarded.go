@@ -0,0 +1,228 @@
+package q
+
+import (
+	"net"
+	"strings"
+
+	"github.com/kataras/q/errors"
+)
+
+const (
+	// forwardedHeader represents the header "Forwarded", RFC 7239
+	forwardedHeader = "Forwarded"
+	// xForwardedForHeader represents the header "X-Forwarded-For"
+	xForwardedForHeader = "X-Forwarded-For"
+	// xForwardedProtoHeader represents the header "X-Forwarded-Proto"
+	xForwardedProtoHeader = "X-Forwarded-Proto"
+	// xForwardedHostHeader represents the header "X-Forwarded-Host"
+	xForwardedHostHeader = "X-Forwarded-Host"
+	// xRealIPHeader represents the header "X-Real-Ip"
+	xRealIPHeader = "X-Real-Ip"
+)
+
+var errInvalidTrustedProxy = errors.New("Invalid trusted proxy: '%s', it should be an IP or a CIDR, i.e '10.0.0.0/8'")
+
+// parseTrustedProxies returns the networks of the Q.TrustedProxies, an IP is a network of its own
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, errInvalidTrustedProxy.Format(proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, errInvalidTrustedProxy.Format(proxy)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// isTrustedProxy returns true if the ip is one of the Q.TrustedProxies
+func (q *Q) isTrustedProxy(ip string) bool {
+	if len(q.trustedProxies) == 0 {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range q.trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHop is a hop of the Forwarded or the X-Forwarded-* headers, the request which a proxy received,
+// the addr is the proxy's client, the proto and the host are the scheme and the host of the request
+type forwardedHop struct {
+	addr  string
+	proto string
+	host  string
+}
+
+// forwardedHops returns the hops of the request, from the client to the nearest proxy,
+// the Forwarded header is preferred over the X-Forwarded-For and the X-Real-Ip
+func (ctx *Context) forwardedHops() []forwardedHop {
+	if forwarded := ctx.Request.Header[forwardedHeader]; len(forwarded) > 0 {
+		var hops []forwardedHop
+		for _, element := range splitQuoted(strings.Join(forwarded, ","), ',') {
+			var hop forwardedHop
+			for _, pair := range splitQuoted(element, ';') {
+				idx := strings.IndexByte(pair, '=')
+				if idx == -1 {
+					continue
+				}
+				value := strings.Trim(strings.TrimSpace(pair[idx+1:]), `"`)
+				switch strings.ToLower(strings.TrimSpace(pair[0:idx])) {
+				case "for":
+					hop.addr = value
+				case "proto":
+					hop.proto = value
+				case "host":
+					hop.host = value
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+
+	// the X-Forwarded-Proto and the X-Forwarded-Host are setted, or appended, by the nearest proxy
+	proto := lastListValue(ctx.RequestHeader(xForwardedProtoHeader))
+	host := lastListValue(ctx.RequestHeader(xForwardedHostHeader))
+	if forwardedFor := ctx.Request.Header[xForwardedForHeader]; len(forwardedFor) > 0 {
+		var hops []forwardedHop
+		for _, addr := range strings.Split(strings.Join(forwardedFor, ","), ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				hops = append(hops, forwardedHop{addr: addr, proto: proto, host: host})
+			}
+		}
+		return hops
+	}
+	if realIP := strings.TrimSpace(ctx.RequestHeader(xRealIPHeader)); realIP != "" || proto != "" || host != "" {
+		return []forwardedHop{{addr: realIP, proto: proto, host: host}}
+	}
+	return nil
+}
+
+// origin returns the hop of the original request, it walks the hops from the nearest proxy to the client and stops to the first untrusted address,
+// returns false if the request's peer is not a trusted proxy, its headers can't be trusted then, or it has not any forwarded header
+func (ctx *Context) origin() (forwardedHop, bool) {
+	addr := ctx.RequestIP()
+	if !ctx.q.isTrustedProxy(addr) {
+		return forwardedHop{}, false
+	}
+	hops := ctx.forwardedHops()
+	if len(hops) == 0 {
+		return forwardedHop{}, false
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		ip := parseNodeIP(hop.addr)
+		if ip == "" {
+			// unknown or obfuscated, keep the last known address
+			hop.addr = addr
+			return hop, true
+		}
+		hop.addr = ip
+		if i == 0 || !ctx.q.isTrustedProxy(ip) {
+			return hop, true
+		}
+		addr = ip
+	}
+	return forwardedHop{}, false
+}
+
+// parseNodeIP returns the IP of a node, i.e '192.0.2.43', '192.0.2.43:47011' or '[2001:db8:cafe::17]:4711', empty if it's not an IP, i.e 'unknown' or '_hidden'
+func parseNodeIP(node string) string {
+	node = strings.TrimSpace(node)
+	if strings.HasPrefix(node, "[") {
+		if idx := strings.IndexByte(node, ']'); idx != -1 {
+			node = node[1:idx]
+		}
+	} else if strings.Count(node, ":") == 1 {
+		node = node[0:strings.IndexByte(node, ':')]
+	}
+	if ip := net.ParseIP(node); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// lastListValue returns the last value of a comma separated header value
+func lastListValue(value string) string {
+	if idx := strings.LastIndexByte(value, ','); idx != -1 {
+		value = value[idx+1:]
+	}
+	return strings.TrimSpace(value)
+}
+
+// splitQuoted splits the s by the sep, except inside the quoted strings
+func splitQuoted(s string, sep byte) []string {
+	var (
+		parts    []string
+		quoted   bool
+		start    int
+		escaping bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaping:
+			escaping = false
+		case c == '\\' && quoted:
+			escaping = true
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// RemoteAddr is like RequestIP but it checks for proxy servers also, it returns the real client's IP if the request comes through the Q.TrustedProxies,
+// by the Forwarded, the X-Forwarded-For or the X-Real-Ip header, the addresses of the trusted proxies are skipped.
+// The headers of the untrusted peers are ignored, so the clients can't spoof their address
+func (ctx *Context) RemoteAddr() string {
+	if hop, ok := ctx.origin(); ok && hop.addr != "" {
+		return hop.addr
+	}
+	return ctx.RequestIP()
+}
+
+// Scheme returns the scheme of the original request, "http" or "https", by the Forwarded's proto or the X-Forwarded-Proto of the Q.TrustedProxies,
+// by the request's TLS otherwise
+func (ctx *Context) Scheme() string {
+	if hop, ok := ctx.origin(); ok {
+		if proto := strings.ToLower(hop.proto); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if ctx.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host of the original request, by the Forwarded's host or the X-Forwarded-Host of the Q.TrustedProxies,
+// the request's Host otherwise
+func (ctx *Context) Host() string {
+	if hop, ok := ctx.origin(); ok && hop.host != "" && !strings.ContainsAny(hop.host, " /\\@") {
+		return hop.host
+	}
+	return ctx.Request.Host
+}
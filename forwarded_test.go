@@ -0,0 +1,41 @@
+package q
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	var got [3]string
+	h := func(ctx *Context) { got = [3]string{ctx.RemoteAddr(), ctx.Scheme(), ctx.Host()} }
+	qq := (&Q{Host: "mydomain.com:80", DisableServer: true, TrustedProxies: []string{"10.0.0.0/8", "2001:db8::1"},
+		Request: Request{Entries: Entries{Entry{Method: "GET", Path: "/", Handler: h}}}}).Go()
+	cases := []struct {
+		peer    string
+		headers map[string]string
+		want    [3]string
+	}{
+		{"1.2.3.4:1", map[string]string{"X-Forwarded-For": "9.9.9.9", "X-Real-Ip": "8.8.8.8", "X-Forwarded-Proto": "https"}, [3]string{"1.2.3.4", "http", "example.com"}},
+		{"10.0.0.1:1", map[string]string{"X-Forwarded-For": "6.6.6.6, 5.5.5.5, 10.1.1.1", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "real.com"}, [3]string{"5.5.5.5", "https", "real.com"}},
+		{"10.0.0.1:1", map[string]string{"X-Real-Ip": "7.7.7.7"}, [3]string{"7.7.7.7", "http", "example.com"}},
+		{"10.0.0.1:1", nil, [3]string{"10.0.0.1", "http", "example.com"}},
+		{"[2001:db8::1]:1", map[string]string{"Forwarded": `for=4.4.4.4;proto=http, for="[2001:db8:cafe::17]:4711";proto=https;host="a.com"`}, [3]string{"2001:db8:cafe::17", "https", "a.com"}},
+		{"10.0.0.1:1", map[string]string{"Forwarded": `for=3.3.3.3:80;proto=https;host=b.com, for=10.2.2.2`, "X-Forwarded-For": "1.1.1.1"}, [3]string{"3.3.3.3", "https", "b.com"}},
+		{"10.0.0.1:1", map[string]string{"Forwarded": `for=unknown;proto=https`}, [3]string{"10.0.0.1", "https", "example.com"}},
+		{"10.0.0.1:1", map[string]string{"Forwarded": `for=10.3.3.3;proto=https;host=c.com`}, [3]string{"10.3.3.3", "https", "c.com"}},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = c.peer
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+		qq.ServeHTTP(httptest.NewRecorder(), req)
+		if got != c.want {
+			t.Errorf("%d: %v want %v", i, got, c.want)
+		}
+	}
+	if err := (&Q{Host: "mydomain.com:80", DisableServer: true, TrustedProxies: []string{"nope"}}).Build(); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -1,6 +1,7 @@
 package q
 
 import (
+	"bytes"
 	"mime"
 	"os"
 	"path"
@@ -11,21 +12,13 @@ import (
 	"github.com/kataras/q/errors"
 )
 
-// StaticHandler serves bytes, memory cached
+// StaticHandler serves bytes, memory cached, with a strong ETag of the content and the conditional and range requests support, look ctx.ServeContent
 // a good example of this is how the websocket server uses that to auto-register the javascript client side source
 func StaticHandler(contentType string, content []byte) Handler {
 	modtime := time.Now()
+	etag := ContentETag(content)
 	return func(ctx *Context) {
-		modtimeStr := modtime.UTC().Format(ctx.q.TimeFormat)
-		if t, err := time.Parse(ctx.q.TimeFormat, ctx.RequestHeader(ifModifiedSince)); err == nil && modtime.Before(t.Add(ctx.q.StaticCacheDuration)) {
-			ctx.ResponseWriter.Header().Del(contentType)
-			ctx.ResponseWriter.Header().Del(contentLength)
-			ctx.ResponseWriter.WriteHeader(StatusNotModified)
-			return
-		}
-		ctx.SetContentType(contentType)
-		ctx.ResponseWriter.Header().Set(lastModified, modtimeStr)
-		ctx.ResponseWriter.Write(content)
+		ctx.serveContent(bytes.NewReader(content), int64(len(content)), contentType, modtime, etag, false)
 	}
 }
 
@@ -68,7 +61,11 @@ func (s File) ParseEntry(e Entry) Entry {
 type Dir struct {
 	// Entry , edw 9a borouse na dexete idi ena entry kai na to kanei static se fash me ta upoloipa properties, oxi kai asximh idea..xmm
 	Directory string
-	Gzip      bool
+	// Gzip compresses the files by the encoder which the client prefers, look the Q.Compression, the ranges are not compressed
+	Gzip bool
+	// Precompressed serves the precompressed sibling of a file, if any and if the client accepts its encoding,
+	// the 'app.js.br' (br), the 'app.js.zst' (zstd) or the 'app.js.gz' (gzip) of the 'app.js', by the Accept-Encoding's q-values and then by that order
+	Precompressed bool
 	// StripPrefix is not needed in the most cases
 	// for example
 	// q.Entry{Method: q.MethodGet, Path: "/js", Parser: q.Dir{Directory: "./static/js"}}
@@ -110,6 +107,10 @@ func (s Dir) ParseEntry(e Entry) Entry {
 			return
 		}
 
+		if s.Precompressed && ctx.servePrecompressed(spath) {
+			return
+		}
+
 		ctx.ServeFileContent(spath, s.Gzip)
 	}
 
@@ -163,18 +164,10 @@ func (favicon Favicon) ParseEntry(e Entry) Entry {
 		panic(errDirectoryFileNotFound.Format(favPath, "Couldn't read the data bytes for Favicon: "+err.Error()))
 	}
 
+	modtime := fi.ModTime()
+	etag := ContentETag(cacheFav)
 	h := func(ctx *Context) {
-		modtime := fi.ModTime().UTC().Format(ctx.q.TimeFormat)
-		if t, err := time.Parse(ctx.q.TimeFormat, ctx.RequestHeader(ifModifiedSince)); err == nil && fi.ModTime().Before(t.Add(ctx.q.StaticCacheDuration)) {
-			ctx.ResponseWriter.Header().Del(contentType)
-			ctx.ResponseWriter.Header().Del(contentLength)
-			ctx.ResponseWriter.WriteHeader(StatusNotModified)
-			return
-		}
-
-		ctx.ResponseWriter.Header().Set(contentType, cType)
-		ctx.ResponseWriter.Header().Set(lastModified, modtime)
-		ctx.ResponseWriter.Write(cacheFav)
+		ctx.serveContent(bytes.NewReader(cacheFav), int64(len(cacheFav)), cType, modtime, etag, false)
 	}
 
 	e.Handler = h
@@ -191,6 +184,54 @@ func (favicon Favicon) ParseEntry(e Entry) Entry {
 	return e
 }
 
+// precompressedEncodings are the encodings of the precompressed files and their extensions, by order of preference
+var precompressedEncodings = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+// servePrecompressed serves the precompressed sibling of the file, or of its index.html if it's a directory,
+// returns false if there is not any sibling which the client accepts
+func (ctx *Context) servePrecompressed(filename string) bool {
+	if fi, err := os.Stat(filename); err == nil && fi.IsDir() {
+		filename = path.Join(filename, "index.html")
+	}
+
+	var encodings, siblings []string
+	for _, p := range precompressedEncodings {
+		if fi, err := os.Stat(filename + p.extension); err == nil && !fi.IsDir() {
+			encodings = append(encodings, p.encoding)
+			siblings = append(siblings, filename+p.extension)
+		}
+	}
+	if len(encodings) == 0 {
+		return false
+	}
+	h := ctx.ResponseWriter.Header()
+	h.Add(varyHeader, acceptEncodingHeader)
+	idx := negotiateEncoding(ctx.RequestHeader(acceptEncodingHeader), encodings)
+	if idx == -1 {
+		return false
+	}
+
+	f, err := os.Open(siblings[idx])
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	h.Set(contentEncodingHeader, encodings[idx])
+	ctx.serveContent(f, fi.Size(), typeByExtension(filename), fi.ModTime(), FileETag(fi.Size(), fi.ModTime()), false)
+	return true
+}
+
 // errDirectoryFileNotFound returns an error with message: 'Directory or file %s couldn't found. Trace: +error trace'
 var errDirectoryFileNotFound = errors.New("Directory or file %s couldn't found. Trace: %s")
 
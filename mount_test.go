@@ -0,0 +1,112 @@
+package q
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestMount(t *testing.T) {
+	child := &Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{
+		Errors: Errors{404: func(ctx *Context) {
+			ctx.ResponseWriter.WriteHeader(404)
+			io.WriteString(ctx.ResponseWriter, "child404")
+		}},
+		Entries: Entries{
+			Entry{Name: "child.user", Method: "GET", Path: "/users/:id", Handler: func(ctx *Context) {
+				io.WriteString(ctx.ResponseWriter, ctx.Request.URL.Path+"|"+ctx.Params.String())
+			}},
+			Entry{Method: "GET", Path: "/", Handler: func(ctx *Context) { io.WriteString(ctx.ResponseWriter, "childroot") }},
+		}}}
+	plain := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "plain:"+r.URL.Path) })
+	begins := 0
+	parent := newQ(Entries{
+		Entry{Path: "/admin", Mount: child, Begin: Handlers{func(ctx *Context) { begins++; ctx.Next() }}},
+		Entry{Path: "/raw/", Mount: plain},
+		Entry{Method: "GET", Path: "/", Handler: func(ctx *Context) { io.WriteString(ctx.ResponseWriter, "parent") }},
+	})
+	for _, c := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/admin/users/4", 200, "/users/4|id=4"},
+		{"/admin", 200, "childroot"},
+		{"/admin/", 200, "childroot"},
+		{"/admin/nope", 404, "child404"},
+		{"/raw/a/b", 200, "plain:/a/b"},
+		{"/raw", 200, "plain:/"},
+		{"/", 200, "parent"},
+	} {
+		if rec := serve(parent, "GET", c.path); rec.Code != c.code || rec.Body.String() != c.body {
+			t.Errorf("%s: got %d %q", c.path, rec.Code, rec.Body.String())
+		}
+	}
+	if begins != 4 {
+		t.Errorf("begins %d", begins)
+	}
+	if p := parent.Path("child.user", 7); p != "/admin/users/7" {
+		t.Errorf("path %q", p)
+	}
+	if u := parent.URL("child.user", 7); u != "http://mydomain.com:80/admin/users/7" {
+		t.Errorf("url %q", u)
+	}
+}
+
+func TestMountBuildError(t *testing.T) {
+	child := &Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{Entries: Entries{
+		Entry{Method: "GET", Path: "/a", Handler: echoParams},
+		Entry{Method: "GET", Path: "/a", Handler: echoParams},
+	}}}
+	parent := &Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{Entries: Entries{
+		Entry{Path: "/admin", Mount: child},
+		Entry{Path: "/other", Mount: child},
+	}}}
+	err := parent.Build()
+	if errs, ok := err.(BuildErrors); !ok || len(errs) != 1 {
+		t.Fatalf("expected one build error, got %v", err)
+	}
+	if child.built != nil {
+		t.Fatalf("a mount which failed to build is marked as built")
+	}
+
+	child.Request.Entries = child.Request.Entries[0:1]
+	if err := parent.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if child.built == nil {
+		t.Fatalf("the mount is not built")
+	}
+	if rec := serve(parent, "GET", "/other/a"); rec.Code != 200 {
+		t.Fatalf("got %d", rec.Code)
+	}
+}
+
+func TestMountEscapedSlash(t *testing.T) {
+	plain := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path+"|"+r.URL.EscapedPath())
+	})
+	for _, disablePathEscape := range []bool{false, true} {
+		parent := (&Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{
+			DisablePathEscape: disablePathEscape,
+			Entries:           Entries{Entry{Path: "/raw/", Mount: plain}, Entry{Path: "/a/b", Mount: plain}},
+		}}).Go()
+		cases := map[string]string{
+			"/raw/a%2Fb/c": "/a/b/c|/a%2Fb/c",
+			"/raw/a%20b":   "/a b|/a%20b",
+			"/raw":         "/|/",
+			"/raw/x/y":     "/x/y|/x/y",
+			"/raw/%2F%2Fx": "///x|/%2F%2Fx",
+		}
+		if !disablePathEscape {
+			// the unescaped path matches the prefix
+			cases["/a%2Fb/x"] = "/x|/x"
+			cases["/a%2Fb/c%2Fd"] = "/c/d|/c%2Fd"
+		}
+		for target, want := range cases {
+			if rec := serve(parent, "GET", target); rec.Body.String() != want {
+				t.Errorf("%v %s: got %d %q", disablePathEscape, target, rec.Code, rec.Body.String())
+			}
+		}
+	}
+}
@@ -2,10 +2,17 @@ package q
 
 import (
 	"bytes"
+	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/kataras/q/errors"
 )
@@ -52,6 +59,10 @@ const (
 	slash = "/"
 	// matchEverythingByte is just a byte of '*" rune/char
 	matchEverythingByte = byte('*')
+	// constraintStartByte is the byte of the '(' rune/char, it opens a named parameter's constraint, i.e /users/:id(int)
+	constraintStartByte = byte('(')
+	// constraintEndByte is the byte of the ')' rune/char, it closes a named parameter's constraint
+	constraintEndByte = byte(')')
 
 	isStatic entryCase = iota
 	isRoot
@@ -82,9 +93,11 @@ type (
 		hasWildNode bool
 		tokens      string
 		nodes       []*muxEntry
-		handlers    Handlers
+		route       *route
 		precedence  uint64
 		paramsLen   uint8
+		// constraint is not nil when the node is a named parameter with a constraint, i.e :id(int)
+		constraint *paramConstraint
 	}
 )
 
@@ -97,6 +110,9 @@ var (
 	errMuxEntryWildcardInvalidPlace      = errors.New("Router: Wildcard is only allowed at the end of the path, in the route path: '%s' !")
 	errMuxEntryWildcardConflictshandlers = errors.New("Router: Wildcard  conflicts with existing handlers for the route path: '%s' !")
 	errMuxEntryWildcardMissingSlash      = errors.New("Router: No slash(/) were found before wildcard in the route path: '%s' !")
+	errMuxEntryConstraintUnclosed        = errors.New("Router: Unclosed constraint for the parameter: '%s' in the route path: '%s' !")
+	errMuxEntryConstraintInvalid         = errors.New("Router: Invalid constraint: '%s' in the route path: '%s'. Trace: %s")
+	errMuxEntryConflictsParameter        = errors.New("Router: The parameter: '%s' conflicts with the parameter: '%s' in the route path: '%s', only one named parameter without a constraint is allowed at the same position of the paths !")
 )
 
 // Get returns a value from a key inside this Parameters
@@ -151,10 +167,184 @@ func ParseParams(str string) PathParameters {
 	return params
 }
 
+// paramConstraint is the compiled constraint of a named path parameter
+// a constraint is declared inside parentheses right after the parameter's name, i.e:
+//
+// /users/:id(int)
+// /users/:id(uint)
+// /orders/:order(uuid)
+// /tags/:tag(alpha)
+// /users/:username(min=3,max=20)
+// /users/:username(alpha,max=20)
+// /posts/:slug([a-z0-9-]+)
+//
+// the available keywords are the: int, uint, uuid, alpha, min=$length and max=$length, keywords can be combined with a comma,
+// an unknown keyword is an error, the constraint is compiled as a regexp only if it contains a regexp's special character, i.e '(?:integer)' instead of 'integer',
+// the regexp should match the whole parameter's value, it can't contain a slash, because a named parameter is always a single path segment.
+//
+// A value which doesn't match the constraint falls through to the next named parameter of the same position, if any, otherwise it's a 404,
+// i.e the '/users/:id(int)' and the '/users/:name(alpha)' can be registered together, a parameter without a constraint is tried last.
+type paramConstraint struct {
+	// param is the parameter's name, without the ':' and the constraint, i.e 'id'
+	param string
+	// expr is the constraint's expression as it was declared, i.e 'int'
+	expr     string
+	matchers []func(string) bool
+}
+
+// match returns true if the value passes all the matchers of the constraint
+func (c *paramConstraint) match(value string) bool {
+	for i := range c.matchers {
+		if !c.matchers[i](value) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	errConstraintUnknown = errors.New("Unknown constraint keyword: '%s'")
+	errConstraintLength  = errors.New("Invalid length for the constraint: '%s'")
+	errConstraintEmpty   = errors.New("Empty constraint for the parameter: '%s'")
+)
+
+// constraintRegexpChars are the special characters of a regexp, a constraint without any of them is a list of keywords
+const constraintRegexpChars = "[]{}()|^$\\.+*?"
+
+// findConstraintEnd receives a path and the index of a constraint's '(' and returns the index of its closing ')'
+// returns -1 if the constraint is not closed before the end of the path segment
+func findConstraintEnd(path string, start int) int {
+	depth := 0
+	for i := start; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++ // escaped, skip the next byte
+		case slashByte:
+			return -1
+		case constraintStartByte:
+			depth++
+		case constraintEndByte:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseParamConstraint receives a named parameter's declaration, i.e ':id(int)'
+// and returns its compiled constraint, returns a nil constraint if the parameter has not a constraint
+func parseParamConstraint(param string) (*paramConstraint, error) {
+	start := strings.IndexByte(param, constraintStartByte)
+	if start == -1 || param[len(param)-1] != constraintEndByte {
+		return nil, nil
+	}
+	name := param[1:start]
+	expr := param[start+1 : len(param)-1]
+	if expr == "" {
+		return nil, errConstraintEmpty.Format(name)
+	}
+
+	c := &paramConstraint{param: name, expr: expr}
+	if strings.ContainsAny(expr, constraintRegexpChars) {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, err
+		}
+		c.matchers = []func(string) bool{re.MatchString}
+		return c, nil
+	}
+	// it's a list of keywords, an unknown keyword is not a regexp, i.e 'integer'
+	for _, keyword := range strings.Split(expr, ",") {
+		m, err := constraintKeywordMatcher(strings.TrimSpace(keyword))
+		if err != nil {
+			return nil, err
+		}
+		c.matchers = append(c.matchers, m)
+	}
+	return c, nil
+}
+
+// constraintKeywordMatcher returns the matcher for a constraint's keyword
+func constraintKeywordMatcher(keyword string) (func(string) bool, error) {
+	switch keyword {
+	case "int":
+		return func(s string) bool {
+			_, err := strconv.ParseInt(s, 10, 64)
+			return err == nil
+		}, nil
+	case "uint":
+		return func(s string) bool {
+			_, err := strconv.ParseUint(s, 10, 64)
+			return err == nil
+		}, nil
+	case "uuid":
+		return isUUID, nil
+	case "alpha":
+		return isAlpha, nil
+	}
+
+	if eqIdx := strings.IndexByte(keyword, '='); eqIdx > 0 {
+		n, err := strconv.Atoi(keyword[eqIdx+1:])
+		if err != nil || n < 0 {
+			return nil, errConstraintLength.Format(keyword)
+		}
+		switch keyword[0:eqIdx] {
+		case "min":
+			return func(s string) bool { return utf8.RuneCountInString(s) >= n }, nil
+		case "max":
+			return func(s string) bool { return utf8.RuneCountInString(s) <= n }, nil
+		}
+	}
+
+	return nil, errConstraintUnknown.Format(keyword)
+}
+
+// isUUID returns true if s has the canonical form of an uuid, i.e 6ba7b810-9dad-11d1-80b4-00c04fd430c8
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if i == 8 || i == 13 || i == 18 || i == 23 {
+			if c != '-' {
+				return false
+			}
+			continue
+		}
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') && !(c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// isAlpha returns true if s is not empty and contains only latin letters
+func isAlpha(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
 // getParamsLen returns the parameters length from a given path
 func getParamsLen(path string) uint8 {
 	var n uint
 	for i := 0; i < len(path); i++ {
+		if path[i] == constraintStartByte {
+			// skip the parameter's constraint, a regexp can contain the ':' and '*' too
+			if end := findConstraintEnd(path, i); end != -1 {
+				i = end
+			}
+			continue
+		}
 		if path[i] != ':' && path[i] != '*' { // ParameterStartByte & MatchEverythingByte
 			continue
 		}
@@ -175,7 +365,7 @@ func findLower(a, b int) int {
 }
 
 // add adds a muxEntry to the existing muxEntry or to the tree if no muxEntry has the prefix of
-func (e *muxEntry) add(path string, handlers Handlers) error {
+func (e *muxEntry) add(path string, r *route) error {
 	fullPath := path
 	e.precedence++
 	numParams := getParamsLen(path)
@@ -199,7 +389,7 @@ func (e *muxEntry) add(path string, handlers Handlers) error {
 					hasWildNode: e.hasWildNode,
 					tokens:      e.tokens,
 					nodes:       e.nodes,
-					handlers:    e.handlers,
+					route:       e.route,
 					precedence:  e.precedence - 1,
 				}
 
@@ -212,7 +402,7 @@ func (e *muxEntry) add(path string, handlers Handlers) error {
 				e.nodes = []*muxEntry{&node}
 				e.tokens = string([]byte{e.part[i]})
 				e.part = path[:i]
-				e.handlers = nil
+				e.route = nil
 				e.hasWildNode = false
 			}
 
@@ -220,7 +410,25 @@ func (e *muxEntry) add(path string, handlers Handlers) error {
 				path = path[i:]
 
 				if e.hasWildNode {
-					e = e.nodes[0]
+					next := e.nodes[0]
+					if path[0] == parameterStartByte && next.entryCase == hasParams {
+						// the named parameters of the same position with different constraints are alternatives, i.e :id(int) and :name(alpha)
+						param := path
+						if slashIdx := strings.IndexByte(param, slashByte); slashIdx != -1 {
+							param = param[0:slashIdx]
+						}
+						next = nil
+						for i := range e.nodes {
+							if e.nodes[i].part == param {
+								next = e.nodes[i]
+								break
+							}
+						}
+						if next == nil {
+							return e.addParamAlternative(numParams, path, fullPath, r)
+						}
+					}
+					e = next
 					e.precedence++
 
 					if numParams > e.paramsLen {
@@ -262,26 +470,48 @@ func (e *muxEntry) add(path string, handlers Handlers) error {
 					e.precedenceTo(len(e.tokens) - 1)
 					e = node
 				}
-				e.addNode(numParams, path, fullPath, handlers)
-				return nil
+				return e.addNode(numParams, path, fullPath, r)
 
 			} else if i == len(path) {
-				if e.handlers != nil {
+				if e.route != nil {
 					return errMuxEntryhandlersAlreadyExists.Format(fullPath)
 				}
-				e.handlers = handlers
+				e.route = r
 			}
 			return nil
 		}
 	} else {
-		e.addNode(numParams, path, fullPath, handlers)
+		if err := e.addNode(numParams, path, fullPath, r); err != nil {
+			return err
+		}
 		e.entryCase = isRoot
 	}
 	return nil
 }
 
+// addParamAlternative adds the path, which starts with a named parameter, next to the named parameters of the same position,
+// the parameters with a constraint are tried by their registration order and the one without a constraint is the last,
+// only one parameter without a constraint is allowed at the same position
+func (e *muxEntry) addParamAlternative(numParams uint8, path string, fullPath string, r *route) error {
+	holder := &muxEntry{}
+	if err := holder.addNode(numParams, path, fullPath, r); err != nil {
+		return err
+	}
+	node, last := holder.nodes[0], e.nodes[len(e.nodes)-1]
+	if last.constraint == nil {
+		if node.constraint == nil {
+			return errMuxEntryConflictsParameter.Format(node.part, last.part, fullPath)
+		}
+		// keep the parameter without a constraint last, it accepts any value
+		e.nodes = append(e.nodes[0:len(e.nodes)-1], node, last)
+		return nil
+	}
+	e.nodes = append(e.nodes, node)
+	return nil
+}
+
 // addNode adds a muxEntry as children to other muxEntry
-func (e *muxEntry) addNode(numParams uint8, path string, fullPath string, handlers Handlers) error {
+func (e *muxEntry) addNode(numParams uint8, path string, fullPath string, r *route) error {
 	var offset int
 
 	for i, max := 0, len(path); numParams > 0; i++ {
@@ -299,6 +529,13 @@ func (e *muxEntry) addNode(numParams uint8, path string, fullPath string, handle
 				   	path[i:] + "' in path '" + fullPath + "'")
 				*/
 				return errMuxEntryInvalidWildcard.Format(path[i:], fullPath)
+			case constraintStartByte:
+				// the constraint is the last part of the path segment, i.e /:id(int)/
+				constraintEnd := findConstraintEnd(path, end)
+				if constraintEnd == -1 || (constraintEnd+1 < max && path[constraintEnd+1] != slashByte) {
+					return errMuxEntryConstraintUnclosed.Format(path[i:], fullPath)
+				}
+				end = constraintEnd + 1
 			default:
 				end++
 			}
@@ -319,9 +556,15 @@ func (e *muxEntry) addNode(numParams uint8, path string, fullPath string, handle
 				offset = i
 			}
 
+			constraint, err := parseParamConstraint(path[i:end])
+			if err != nil {
+				return errMuxEntryConstraintInvalid.Format(path[i:end], fullPath, err.Error())
+			}
+
 			child := &muxEntry{
-				entryCase: hasParams,
-				paramsLen: numParams,
+				entryCase:  hasParams,
+				paramsLen:  numParams,
+				constraint: constraint,
 			}
 			e.nodes = []*muxEntry{child}
 			e.hasWildNode = true
@@ -340,6 +583,8 @@ func (e *muxEntry) addNode(numParams uint8, path string, fullPath string, handle
 				e.nodes = []*muxEntry{child}
 				e = child
 			}
+			// continue after the parameter's name and constraint
+			i = end - 1
 
 		} else {
 			if end != max || numParams > 1 {
@@ -371,7 +616,7 @@ func (e *muxEntry) addNode(numParams uint8, path string, fullPath string, handle
 				part:       path[i:],
 				entryCase:  matchEverything,
 				paramsLen:  1,
-				handlers:   handlers,
+				route:      r,
 				precedence: 1,
 			}
 			e.nodes = []*muxEntry{child}
@@ -381,13 +626,13 @@ func (e *muxEntry) addNode(numParams uint8, path string, fullPath string, handle
 	}
 
 	e.part = path[offset:]
-	e.handlers = handlers
+	e.route = r
 
 	return nil
 }
 
-// get is used by the Router, it finds and returns the correct muxEntry for a path
-func (e *muxEntry) get(path string, _params PathParameters) (handlers Handlers, params PathParameters, mustRedirect bool) {
+// get is used by the Router, it finds and returns the correct route for a path
+func (e *muxEntry) get(path string, _params PathParameters) (r *route, params PathParameters, mustRedirect bool) {
 	params = _params
 loop:
 	for {
@@ -404,7 +649,23 @@ loop:
 						}
 					}
 
-					mustRedirect = (path == slash && e.handlers != nil)
+					mustRedirect = (path == slash && e.route != nil)
+					return
+				}
+
+				if len(e.nodes) > 1 {
+					// the named parameters of the same position with different constraints,
+					// the first one which serves the path wins, a value which doesn't match a constraint falls through to the next one
+					base := params
+					for i := range e.nodes {
+						alternative := muxEntry{hasWildNode: true, nodes: e.nodes[i : i+1]}
+						redirect := false
+						if r, params, redirect = alternative.get(path, base); r != nil {
+							return
+						}
+						mustRedirect = mustRedirect || redirect
+					}
+					params = base
 					return
 				}
 
@@ -417,12 +678,24 @@ loop:
 						end++
 					}
 
-					if cap(params) < int(e.paramsLen) {
-						params = make(PathParameters, 0, e.paramsLen)
+					key := e.part[1:]
+					if e.constraint != nil {
+						if !e.constraint.match(path[:end]) {
+							// the value doesn't match with the parameter's constraint, this route can't serve the request
+							return
+						}
+						key = e.constraint.param
+					}
+
+					if cap(params) < len(params)+int(e.paramsLen) {
+						// keep the subdomain's parameters, if any
+						newParams := make(PathParameters, len(params), len(params)+int(e.paramsLen))
+						copy(newParams, params)
+						params = newParams
 					}
 					i := len(params)
 					params = params[:i+1]
-					params[i].Key = e.part[1:]
+					params[i].Key = key
 					params[i].Value = path[:end]
 
 					if end < len(path) {
@@ -436,25 +709,28 @@ loop:
 						return
 					}
 
-					if handlers = e.handlers; handlers != nil {
+					if r = e.route; r != nil {
 						return
 					} else if len(e.nodes) == 1 {
 						e = e.nodes[0]
-						mustRedirect = (e.part == slash && e.handlers != nil)
+						mustRedirect = (e.part == slash && e.route != nil)
 					}
 
 					return
 
 				case matchEverything:
-					if cap(params) < int(e.paramsLen) {
-						params = make(PathParameters, 0, e.paramsLen)
+					if cap(params) < len(params)+int(e.paramsLen) {
+						// keep the subdomain's parameters, if any
+						newParams := make(PathParameters, len(params), len(params)+int(e.paramsLen))
+						copy(newParams, params)
+						params = newParams
 					}
 					i := len(params)
 					params = params[:i+1]
 					params[i].Key = e.part[2:]
 					params[i].Value = path
 
-					handlers = e.handlers
+					r = e.route
 					return
 
 				default:
@@ -462,7 +738,7 @@ loop:
 				}
 			}
 		} else if path == e.part {
-			if handlers = e.handlers; handlers != nil {
+			if r = e.route; r != nil {
 				return
 			}
 
@@ -474,8 +750,8 @@ loop:
 			for i := range e.tokens {
 				if e.tokens[i] == slashByte {
 					e = e.nodes[i]
-					mustRedirect = (len(e.part) == 1 && e.handlers != nil) ||
-						(e.entryCase == matchEverything && e.nodes[0].handlers != nil)
+					mustRedirect = (len(e.part) == 1 && e.route != nil) ||
+						(e.entryCase == matchEverything && e.nodes[0].route != nil)
 					return
 				}
 			}
@@ -485,7 +761,7 @@ loop:
 
 		mustRedirect = (path == slash) ||
 			(len(e.part) == len(path)+1 && e.part[len(path)] == slashByte &&
-				path == e.part[:len(e.part)-1] && e.handlers != nil)
+				path == e.part[:len(e.part)-1] && e.route != nil)
 		return
 	}
 }
@@ -519,14 +795,31 @@ func (e *muxEntry) precedenceTo(index int) int {
 
 type (
 	route struct {
-		// if no name given then it's the subdomain+path
-		name           string
-		subdomain      string
+		// if no name given then it's the path+subdomain+host
+		name      string
+		subdomain string
+		// host is the full host of the route, i.e example.org, it's empty for the Q.Host's and its subdomains' routes
+		host           string
 		method         string
 		path           string
 		handlers       Handlers
 		formattedPath  string
 		formattedParts int
+		// constraints has the same length as the formattedParts, the part's constraint is nil if the parameter has not a constraint
+		constraints []*paramConstraint
+		// paramNames are the names of the named parameters, same length as the formattedParts
+		paramNames []string
+		// errors are the custom http errors of the route's host, nil if the route has not a full host
+		errors Errors
+		// mount is the Q instance which is mounted to the route's path, the mountPrefix, if any
+		mount       *Q
+		mountPrefix string
+		// timeout is the Entry.Timeout, kept for the ReplaceHandlers
+		timeout       time.Duration
+		timeoutStatus int
+		// begin and done are the Entry.Begin and Done, with its parents', kept for the ReplaceHandlers
+		begin Handlers
+		done  Handlers
 	}
 
 	bySubdomain []*route
@@ -540,43 +833,285 @@ func (s bySubdomain) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 func (s bySubdomain) Less(i, j int) bool {
+	// the static subdomains first, then the dynamic subdomains and the routes without subdomain last, because they accept any host
+	if ri, rj := subdomainRank(s[i].subdomain), subdomainRank(s[j].subdomain); ri != rj {
+		return ri < rj
+	} else if ri == 1 {
+		// the dynamic subdomains with less wildcards first and then with less named parameters, i.e 'admin.:tenant.' before ':region.:tenant.' before '*.'
+		if wi, wj := strings.Count(s[i].subdomain, "*"), strings.Count(s[j].subdomain, "*"); wi != wj {
+			return wi < wj
+		}
+		if pi, pj := strings.Count(s[i].subdomain, ":"), strings.Count(s[j].subdomain, ":"); pi != pj {
+			return pi < pj
+		}
+	}
 	return len(s[i].Subdomain()) > len(s[j].Subdomain())
 }
 
-func newRoute(method string, subdomain string, path string, handlers Handlers) *route {
-	r := &route{name: path + subdomain, method: method, subdomain: subdomain, path: path, handlers: handlers}
+func subdomainRank(subdomain string) int {
+	if subdomain == "" {
+		return 2
+	}
+	if isDynamicSubdomain(subdomain) {
+		return 1
+	}
+	return 0
+}
+
+// isDynamicSubdomain returns true if the subdomain has a wildcard or a named parameter, i.e '*.', ':tenant.' or 'admin.:tenant.'
+func isDynamicSubdomain(subdomain string) bool {
+	return strings.Contains(subdomain, dynamicSubdomainIndicator) || strings.IndexByte(subdomain, ':') != -1
+}
+
+// splitSubdomain returns the labels of the subdomain, i.e ':region.:tenant.' returns [':region', ':tenant']
+func splitSubdomain(subdomain string) []string {
+	return strings.Split(strings.TrimSuffix(subdomain, "."), ".")
+}
+
+// matchSubdomain returns the params with the values of the subdomain's named parameters appended and true if the request's subdomain matches with the labels,
+// a label can be static, a named parameter (':tenant') or a wildcard ('*'), the first label's wildcard matches one or more levels.
+func matchSubdomain(labels []string, subdomain string, params PathParameters) (PathParameters, bool) {
+	requestLabels := strings.Split(subdomain, ".")
+	if labels[0] == "*" {
+		if len(requestLabels) < len(labels) {
+			return params, false
+		}
+		// the leftmost extra levels belong to the wildcard
+		requestLabels = requestLabels[len(requestLabels)-len(labels)+1:]
+		labels = labels[1:]
+	} else if len(requestLabels) != len(labels) {
+		return params, false
+	}
+
+	for i, label := range labels {
+		value := requestLabels[i]
+		if value == "" {
+			return params, false
+		}
+		if label[0] == ':' {
+			params = append(params, PathParameter{Key: label[1:], Value: value})
+		} else if label != "*" && !strings.EqualFold(label, value) {
+			return params, false
+		}
+	}
+	return params, true
+}
+
+// formatSubdomain returns the subdomain with the values of its wildcards and named parameters and the rest of the arguments,
+// the values are the first arguments, in order, if the first argument is a Map then the named parameters' values are taken from it, by their names.
+// Returns false if a value is missing.
+func formatSubdomain(subdomain string, args []interface{}) (string, []interface{}, bool) {
+	var named Map
+	if len(args) > 0 {
+		if named, _ = args[0].(Map); named != nil {
+			args = args[1:]
+		}
+	}
+
+	result := ""
+	for _, label := range splitSubdomain(subdomain) {
+		if label == "*" || label[0] == ':' {
+			var value interface{}
+			if named != nil && label[0] == ':' {
+				value = named[label[1:]]
+			} else if len(args) > 0 {
+				value = args[0]
+				args = args[1:]
+			}
+			if value == nil {
+				return "", args, false
+			}
+			if label = fmt.Sprintf("%v", value); label == "" {
+				return "", args, false
+			}
+		}
+		result += label + "."
+	}
+	return result, args, true
+}
+
+func newRoute(method string, subdomain string, host string, path string, handlers Handlers) *route {
+	r := &route{name: path + subdomain + host, method: method, subdomain: subdomain, host: host, path: path, handlers: handlers}
 	r.formatPath()
 	return r
 }
 
 func (r *route) formatPath() {
 	// we don't care about performance here.
-	n1Len := strings.Count(r.path, ":")
-	isMatchEverything := len(r.path) > 0 && r.path[len(r.path)-1] == matchEverythingByte
-	if n1Len == 0 && !isMatchEverything {
+	if strings.IndexByte(r.path, parameterStartByte) == -1 && strings.IndexByte(r.path, matchEverythingByte) == -1 {
 		// its a static
 		return
 	}
-	if n1Len == 0 && isMatchEverything {
-		//if we have something like: /mypath/anything/* -> /mypatch/anything/%v
-		r.formattedPath = r.path[0:len(r.path)-2] + "%v"
-		r.formattedParts++
-		return
+
+	segments := strings.Split(r.path, slash)
+	for i, v := range segments {
+		if len(v) > 0 && (v[0] == parameterStartByte || v[0] == matchEverythingByte) {
+			r.formattedParts++
+			segments[i] = "%v"
+			// the errors are reported by the muxEntry on build, here we just keep the valid constraints
+			constraint, _ := parseParamConstraint(v)
+			r.constraints = append(r.constraints, constraint)
+			name := v[1:]
+			if idx := strings.IndexByte(name, constraintStartByte); idx != -1 {
+				name = name[0:idx]
+			}
+			r.paramNames = append(r.paramNames, name)
+		} else {
+			// the static parts are escaped but not formatted
+			segments[i] = strings.Replace(escapePath(v), "%", "%%", -1)
+		}
+	}
+	r.formattedPath = strings.Join(segments, slash)
+}
+
+// validArgs returns false if an argument doesn't match with its named parameter's constraint
+func (r *route) validArgs(args []interface{}) bool {
+	for i := range args {
+		if i >= len(r.constraints) {
+			break
+		}
+		if c := r.constraints[i]; c != nil && !c.match(fmt.Sprintf("%v", args[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// newError returns an EntryError for the route
+func (r *route) newError(err error) EntryError {
+	e := EntryError{Method: r.method, Path: r.subdomain + r.host + r.path, Err: err}
+	if r.name != r.path+r.subdomain+r.host {
+		// has a user-defined name
+		e.Name = r.name
+	}
+	return e
+}
+
+var (
+	errReverseParamMissing    = errors.New("Reverse: the value of the parameter: '%s' of the entry: '%s' is missing!")
+	errReverseParamType       = errors.New("Reverse: the value of the parameter: '%s' of the entry: '%s' has an unsupported type: %T!")
+	errReverseParamConstraint = errors.New("Reverse: the value: '%s' of the parameter: '%s' of the entry: '%s' doesn't match with the parameter's constraint!")
+)
+
+// reverse returns the path of the route with the named parameters' values, by their names, from the params, and the encoded query, if any
+func (r *route) reverse(params Map, query url.Values) (string, error) {
+	path := r.path
+	if r.formattedParts == 0 {
+		path = escapePath(path)
+	} else {
+		values := make([]interface{}, len(r.paramNames))
+		// only the last part can be a match everything parameter
+		lastPart := path[strings.LastIndexByte(path, slashByte)+1:]
+		for i, name := range r.paramNames {
+			isMatchEverything := i == len(r.paramNames)-1 && len(lastPart) > 0 && lastPart[0] == matchEverythingByte
+			value, err := r.reverseParam(name, params)
+			if err != nil {
+				return "", err
+			}
+			if c := r.constraints[i]; c != nil && !c.match(value) {
+				return "", errReverseParamConstraint.Format(value, name, r.name)
+			}
+			values[i] = escapeParamValue(value, isMatchEverything)
+		}
+		path = fmt.Sprintf(r.formattedPath, values...)
 	}
 
-	tempPath := r.path
-	splittedN1 := strings.Split(r.path, "/")
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path, nil
+}
 
-	for _, v := range splittedN1 {
-		if len(v) > 0 {
-			if v[0] == ':' || v[0] == matchEverythingByte {
-				r.formattedParts++
-				tempPath = strings.Replace(tempPath, v, "%v", -1) // n1Len, but let it we don't care about performance here.
+// reverseSubdomain returns the subdomain of the route with the wildcards' and the named parameters' values from the params,
+// the wildcard's value is the params["*"]
+func (r *route) reverseSubdomain(params Map) (string, error) {
+	subdomain := ""
+	for _, label := range splitSubdomain(r.subdomain) {
+		if label == "*" || label[0] == parameterStartByte {
+			name := label
+			if label[0] == parameterStartByte {
+				name = label[1:]
+			}
+			value, err := r.reverseParam(name, params)
+			if err != nil {
+				return "", err
 			}
+			label = value
 		}
+		subdomain += label + "."
+	}
+	return subdomain, nil
+}
+
+// reverseParam returns the string value of a parameter from the params, or an error if it's missing or its type is not supported
+func (r *route) reverseParam(name string, params Map) (string, error) {
+	v, found := params[name]
+	if !found || v == nil {
+		return "", errReverseParamMissing.Format(name, r.name)
+	}
+	value, ok := formatParamValue(v)
+	if !ok {
+		return "", errReverseParamType.Format(name, r.name, v)
+	}
+	if value == "" {
+		return "", errReverseParamMissing.Format(name, r.name)
+	}
+	return value, nil
+}
 
+// formatParamValue returns the string of a parameter's value, strings, bools, integers, floats and fmt.Stringer are supported,
+// and the []string for the match everything parameter ('*path'), the parts are joined by slash
+func formatParamValue(v interface{}) (string, bool) {
+	switch value := v.(type) {
+	case string:
+		return value, true
+	case []string:
+		return strings.Join(value, slash), true
+	case bool:
+		return strconv.FormatBool(value), true
+	case int:
+		return strconv.Itoa(value), true
+	case int8:
+		return strconv.FormatInt(int64(value), 10), true
+	case int16:
+		return strconv.FormatInt(int64(value), 10), true
+	case int32:
+		return strconv.FormatInt(int64(value), 10), true
+	case int64:
+		return strconv.FormatInt(value, 10), true
+	case uint:
+		return strconv.FormatUint(uint64(value), 10), true
+	case uint8:
+		return strconv.FormatUint(uint64(value), 10), true
+	case uint16:
+		return strconv.FormatUint(uint64(value), 10), true
+	case uint32:
+		return strconv.FormatUint(uint64(value), 10), true
+	case uint64:
+		return strconv.FormatUint(value, 10), true
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case fmt.Stringer:
+		return value.String(), true
 	}
-	r.formattedPath = tempPath
+	return "", false
+}
+
+// escapePath returns the escaped path, the slashes are kept, i.e '/100%' is '/100%25'
+func escapePath(path string) string {
+	return (&url.URL{Path: path}).EscapedPath()
+}
+
+// escapeParamValue returns the escaped value of a parameter,
+// the slashes are escaped too, except for the match everything parameter ('*path') which can have more than one path's parts
+func escapeParamValue(value string, isMatchEverything bool) string {
+	escaped := escapePath(value)
+	if !isMatchEverything {
+		escaped = strings.Replace(escaped, slash, "%2F", -1)
+	}
+	return escaped
 }
 
 func (r *route) setName(newName string) {
@@ -592,6 +1127,10 @@ func (r route) Subdomain() string {
 	return r.subdomain
 }
 
+func (r route) Host() string {
+	return r.host
+}
+
 func (r route) Method() string {
 	return r.method
 }
@@ -617,17 +1156,26 @@ type (
 		// subdomain is empty for default-hostname routes,
 		// ex: mysubdomain.
 		subdomain string
-		entry     *muxEntry
-		next      *muxTree
+		// subdomainLabels are the labels of a dynamic subdomain, nil if the subdomain is static
+		subdomainLabels []string
+		// host is not empty for full host routes, ex: example.org, *.example.org, example.org:8080
+		host string
+		// errors are the custom http errors of the host, shared between the trees of the same host
+		errors Errors
+		entry  *muxEntry
+		next   *muxTree
 	}
 
 	serveMux struct {
 		lookups []*route
-		// if any of the trees contains not empty subdomain
-		hosts        bool
-		allowOptions bool // if setted to true to allow all routes to be served to the client when http method 'OPTIONS', useful when user uses the Cors middleware
-		tree         *muxTree
-		mu           sync.Mutex
+		// optionsHandlers if not nil then the OPTIONS requests to a registered path, which has not an OPTIONS route, are served by these handlers
+		// instead of the 405 Method Not Allowed, setted by the Request.AllowMethodOptions
+		optionsHandlers Handlers
+		// tree keeps the *muxTree which serves the requests,
+		// it's never changed, a new tree is builded and swapped when the routes are changed at runtime
+		tree atomic.Value
+		// mu protects the lookups, readers are the .lookup and .routes, writers are the .register and .update
+		mu sync.RWMutex
 	}
 )
 
@@ -639,34 +1187,22 @@ func newServeMux() *serveMux {
 	return mux
 }
 
-func (mux *serveMux) getTree(method string, subdomain string) (tree *muxTree) {
-	tree = mux.tree
-	for tree != nil {
-		if tree.method == method && tree.subdomain == subdomain {
-			return
-		}
-		tree = tree.next
-	}
-	// tree is nil here, return that.
-	return
+// getTree returns the tree which serves the requests, nil if the mux is not builded yet
+func (mux *serveMux) getTree() *muxTree {
+	tree, _ := mux.tree.Load().(*muxTree)
+	return tree
 }
 
-func (mux *serveMux) register(method string, subdomain string, path string, handlers Handlers) *route {
+func (mux *serveMux) register(routes ...*route) {
 	mux.mu.Lock()
-	defer mux.mu.Unlock()
-
-	if subdomain != "" {
-		mux.hosts = true
-	}
-
 	// add to the lookups, it's just a collection of routes information
-	lookup := newRoute(method, subdomain, path, handlers)
-	mux.lookups = append(mux.lookups, lookup)
-	//fmt.Printf("mux.go:761:Method: %s, Path: %s, Handlers: %d\n", lookup.method, lookup.path, len(lookup.handlers))
-	return lookup
+	mux.lookups = append(mux.lookups, routes...)
+	mux.mu.Unlock()
 }
 
 func (mux *serveMux) lookup(routeName string) *route {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
 	for i := range mux.lookups {
 		if r := mux.lookups[i]; r.name == routeName {
 			return r
@@ -675,23 +1211,109 @@ func (mux *serveMux) lookup(routeName string) *route {
 	return nil
 }
 
+// lookupMounted returns a route, found by its name, of the mounted Q instances,
+// it's a copy with the mount's prefix and the mount's subdomain and host, nil if not found
+func (mux *serveMux) lookupMounted(routeName string) *route {
+	for _, r := range mux.routes() {
+		if r.mount == nil {
+			continue
+		}
+		if mr := r.mount.lookup(routeName); mr != nil {
+			mounted := newRoute(mr.method, r.subdomain, r.host, r.mountPrefix+mr.path, mr.handlers)
+			mounted.setName(mr.name)
+			return mounted
+		}
+	}
+	return nil
+}
+
+// routes returns a copy of the registered routes
+func (mux *serveMux) routes() []*route {
+	mux.mu.RLock()
+	routes := make([]*route, len(mux.lookups))
+	copy(routes, mux.lookups)
+	mux.mu.RUnlock()
+	return routes
+}
+
 // build collects all routes info and adds them to the registry in order to be served from the request handler
-// this happens once when server is setting the mux's handler.
-func (mux *serveMux) build() {
-	mux.tree = nil
-	sort.Sort(bySubdomain(mux.lookups))
-	for _, r := range mux.lookups {
+// this happens once before the server is setting the mux's handler, returns the BuildErrors of all routes which couldn't be registered.
+func (mux *serveMux) build() error {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	tree, err := buildTree(mux.lookups)
+	if err != nil {
+		return err
+	}
+	mux.tree.Store(tree)
+	return nil
+}
+
+// update is used to change the routes of a running mux,
+// it passes a copy of the lookups to the 'change' func and builds a new tree from its result,
+// if the new tree is builded without errors then the lookups and the tree are swapped, the requests are served by the new tree without any downtime.
+// If an error happens the mux stays untouched.
+func (mux *serveMux) update(change func([]*route) ([]*route, error)) error {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	lookups := make([]*route, len(mux.lookups))
+	copy(lookups, mux.lookups)
+
+	lookups, err := change(lookups)
+	if err != nil {
+		return err
+	}
+
+	tree, err := buildTree(lookups)
+	if err != nil {
+		return err
+	}
+
+	mux.lookups = lookups
+	mux.tree.Store(tree)
+	return nil
+}
+
+// buildTree sorts the routes and adds them to a new registry tree,
+// returns the first muxTree(the root) of the registry, the rest are linked by the .next field,
+// or the BuildErrors with an EntryError for each route which couldn't be registered, i.e conflicts with another route
+func buildTree(lookups []*route) (*muxTree, error) {
+	var root *muxTree
+	var errs BuildErrors
+	hostErrors := make(map[string]Errors)
+	sort.Sort(bySubdomain(lookups))
+	for _, r := range lookups {
+		if r.host != "" {
+			// the errors of a host are collected by all of its routes
+			if hostErrors[r.host] == nil {
+				hostErrors[r.host] = make(Errors)
+			}
+			for statusCode, h := range r.errors {
+				hostErrors[r.host][statusCode] = h
+			}
+		}
 		// add to the registry tree
-		tree := mux.getTree(r.method, r.subdomain)
+		var tree *muxTree
+		for t := root; t != nil; t = t.next {
+			if t.method == r.method && t.subdomain == r.subdomain && t.host == r.host {
+				tree = t
+				break
+			}
+		}
+
 		if tree == nil {
 			//first time we register a route to this method with this domain
-			tree = &muxTree{method: r.method, subdomain: r.subdomain, entry: &muxEntry{}, next: nil}
-			if mux.tree == nil {
+			tree = &muxTree{method: r.method, subdomain: r.subdomain, host: r.host, errors: hostErrors[r.host], entry: &muxEntry{}, next: nil}
+			if r.host == "" && isDynamicSubdomain(r.subdomain) {
+				tree.subdomainLabels = splitSubdomain(r.subdomain)
+			}
+			if root == nil {
 				// it's the first entry
-				mux.tree = tree
+				root = tree
 			} else {
 				// find the last tree and make the .next to the tree we created before
-				lastTree := mux.tree
+				lastTree := root
 				for lastTree != nil {
 					if lastTree.next == nil {
 						lastTree.next = tree
@@ -703,15 +1325,22 @@ func (mux *serveMux) build() {
 		}
 		// I decide that it's better to explicit give subdomain and a path to it than registedPath(mysubdomain./something) now its: subdomain: mysubdomain., path: /something
 		// we have different tree for each of subdomains, now you can use everything you can use with the normal paths ( before you couldn't set /any/*path)
-		if err := tree.entry.add(r.path, r.handlers); err != nil {
-			panic(err.Error())
+		if len(r.handlers) == 0 {
+			errs = append(errs, r.newError(errEntryNoHandlers.Return()))
+			continue
+		}
+		if err := tree.entry.add(r.path, r); err != nil {
+			// keep going, report all conflicts at once
+			errs = append(errs, r.newError(err))
 		}
 	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return root, nil
 }
 
 func (mux *serveMux) Handler(escapePath bool, correctPath bool, host string) Handler {
-
-	mux.build()
 	// optimize this once once, we could do that: context.RequestPath(mux.escapePath), but we lose some nanoseconds on if :)
 	getRequestPath := func(ctx *Context) string {
 		return ctx.Request.URL.EscapedPath()
@@ -720,17 +1349,6 @@ func (mux *serveMux) Handler(escapePath bool, correctPath bool, host string) Han
 		getRequestPath = func(ctx *Context) string { return ctx.Request.URL.Path }
 	}
 
-	methodEqual := func(treeMethod string, reqMethod string) bool {
-		return treeMethod == reqMethod
-	}
-
-	// check for cors conflicts
-	if mux.allowOptions {
-		methodEqual = func(treeMethod string, reqMethod string) bool {
-			return treeMethod == reqMethod || reqMethod == MethodOptions
-		}
-	}
-
 	// we don't need the host to have mydomain.com:80 , we want just the mydomain.com
 	host = parseHost(host)
 	if portIdx := strings.IndexByte(host, ':'); portIdx > 0 {
@@ -741,41 +1359,29 @@ func (mux *serveMux) Handler(escapePath bool, correctPath bool, host string) Han
 	}
 
 	return func(ctx *Context) {
-		tree := mux.tree
 		routePath := getRequestPath(ctx)
-		for tree != nil {
-			if !methodEqual(tree.method, ctx.Request.Method) {
-				// we break any CORS OPTIONS method
-				// but for performance reasons if user wants http method OPTIONS to be served
-				// then must register it with .Options(...)
-				tree = tree.next
+		root := mux.getTree()
+		// the full host which the request belongs to, if any, its errors are used by the ctx.EmitError
+		virtualHost := mux.virtualHost(root, ctx.Request.Host)
+		if virtualHost != nil {
+			ctx.errors = virtualHost.errors
+		}
+		for tree := root; tree != nil; tree = tree.next {
+			if tree.method != ctx.Request.Method {
 				continue
 			}
-			// we have at least one subdomain on the root
-			if mux.hosts && tree.subdomain != "" {
-				requestHost := ctx.Request.Host // on net/http that gives the full host, no just the main host(name)
-
-				if strings.Index(tree.subdomain, dynamicSubdomainIndicator) != -1 {
-				} else {
-					// mux.host = mydomain.com:8080, the subdomain for example is api.,
-					// so the host must be api.mydomain.com:8080
-					if tree.subdomain+host != requestHost {
-						// go to the next tree, we have a subdomain but it is not the correct
-						tree = tree.next
-						continue
-					}
-
-				} //else {
-				//("it's subdomain but the request is the same as the listening addr mux.host == requestHost =>" + mux.host + "=" + requestHost + " ____ and tree's subdomain was: " + tree.subdomain)
-				//		tree = tree.next
-				//		continue
-				//}
+			// the subdomain's named parameters are first
+			params, ok := mux.matchHost(tree, ctx.Request.Host, host, virtualHost, ctx.Params)
+			if !ok {
+				continue
 			}
-			handlers, params, mustRedirect := tree.entry.get(routePath, ctx.Params) // pass the parameters here for 0 allocation
-			if handlers != nil {
+
+			r, params, mustRedirect := tree.entry.get(routePath, params) // pass the parameters here for 0 allocation
+			if r != nil {
 				// ok we found the correct route, serve it and exit entirely from here
 				ctx.Params = params
-				ctx.handlers = handlers
+				ctx.handlers = r.handlers
+				ctx.entry = r
 				//ctx.Request.Header.SetUserAgentBytes(DefaultUserAgent)
 				ctx.Serve()
 				return
@@ -807,11 +1413,113 @@ func (mux *serveMux) Handler(escapePath bool, correctPath bool, host string) Han
 			// not found
 			break
 		}
+
+		// the path may be registered for other http methods
+		if allowed := mux.allowedMethods(root, ctx, routePath, host, virtualHost); allowed != "" {
+			if ctx.Request.Method == MethodOptions && mux.optionsHandlers != nil {
+				ctx.SetHeader(allowHeader, allowed+", "+MethodOptions)
+				ctx.handlers = mux.optionsHandlers
+				ctx.Serve()
+				return
+			}
+			ctx.SetHeader(allowHeader, allowed)
+			ctx.EmitError(StatusMethodNotAllowed)
+			return
+		}
+
 		ctx.EmitError(StatusNotFound)
 		return
 	}
 }
 
+// virtualHost returns the first tree of the full host which the requestHost belongs to,
+// the exact hosts have priority over the wildcard hosts, returns nil if the requestHost doesn't belong to a full host
+func (mux *serveMux) virtualHost(root *muxTree, requestHost string) *muxTree {
+	var wildcard *muxTree
+	for tree := root; tree != nil; tree = tree.next {
+		if tree.host == "" {
+			continue
+		}
+		if isWildcard := strings.HasPrefix(tree.host, dynamicSubdomainIndicator); !isWildcard {
+			if matchVirtualHost(tree.host, requestHost) {
+				return tree
+			}
+		} else if wildcard == nil && matchVirtualHost(tree.host, requestHost) {
+			wildcard = tree
+		}
+	}
+	return wildcard
+}
+
+// matchVirtualHost returns true if the requestHost matches with the full host,
+// the port is ignored if the host has not a port and the '*.' matches one or more subdomains
+func matchVirtualHost(host string, requestHost string) bool {
+	if strings.IndexByte(host, ':') == -1 {
+		requestHost = parseHostname(requestHost)
+	}
+	requestHost = strings.ToLower(requestHost)
+	if strings.HasPrefix(host, dynamicSubdomainIndicator) {
+		// *.example.org, match with the .example.org
+		domain := host[1:]
+		return len(requestHost) > len(domain) && strings.HasSuffix(requestHost, domain)
+	}
+	return host == requestHost
+}
+
+// matchHost returns true if the tree can serve a request to the requestHost and the params with the values of the subdomain's named parameters, if any,
+// host is the listening host without the :80,
+// virtualHost is the tree of the full host which the requestHost belongs to, if any, only the trees of that host can serve the request
+func (mux *serveMux) matchHost(tree *muxTree, requestHost string, host string, virtualHost *muxTree, params PathParameters) (PathParameters, bool) {
+	if virtualHost != nil || tree.host != "" {
+		return params, virtualHost != nil && tree.host == virtualHost.host
+	}
+
+	if tree.subdomain == "" {
+		return params, true
+	}
+
+	if tree.subdomainLabels != nil {
+		// mux.host = mydomain.com:8080, the subdomain for example is :tenant.,
+		// so the host must be $tenant.mydomain.com:8080
+		if len(requestHost) <= len(host)+1 || !strings.HasSuffix(requestHost, host) || requestHost[len(requestHost)-len(host)-1] != '.' {
+			return params, false
+		}
+		return matchSubdomain(tree.subdomainLabels, requestHost[0:len(requestHost)-len(host)-1], params)
+	}
+	// mux.host = mydomain.com:8080, the subdomain for example is api.,
+	// so the host must be api.mydomain.com:8080
+	return params, tree.subdomain+host == requestHost
+}
+
+// allowedMethods returns the http methods, separated by comma, which have a route to serve the requested path,
+// returns empty string if the path is not registered at all
+func (mux *serveMux) allowedMethods(root *muxTree, ctx *Context, routePath string, host string, virtualHost *muxTree) string {
+	allowed := ""
+	for _, method := range MethodsAll {
+		if method == ctx.Request.Method {
+			continue
+		}
+		// like the request handler, only the first tree which accepts the host can serve the method
+		for tree := root; tree != nil; tree = tree.next {
+			if tree.method != method {
+				continue
+			}
+			params, ok := mux.matchHost(tree, ctx.Request.Host, host, virtualHost, ctx.Params[0:0])
+			if !ok {
+				continue
+			}
+			if r, _, _ := tree.entry.get(routePath, params); r != nil {
+				if allowed != "" {
+					allowed += ", "
+				}
+				allowed += method
+			}
+			break
+		}
+	}
+	return allowed
+}
+
 var htmlReplacer = strings.NewReplacer(
 	"&", "&amp;",
 	"<", "&lt;",
@@ -0,0 +1,163 @@
+package q
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serve serves a request of the method and the target,
+// the headers are pairs of a key and a value, the "Host" sets the request's host.
+func serve(qq *Q, method, target string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		if headers[i] == "Host" {
+			req.Host = headers[i+1]
+			continue
+		}
+		req.Header.Add(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	qq.ServeHTTP(rec, req)
+	return rec
+}
+
+func echoParams(ctx *Context) { io.WriteString(ctx.ResponseWriter, ctx.Params.String()) }
+
+func writeS(s string) Handler { return func(ctx *Context) { io.WriteString(ctx.ResponseWriter, s) } }
+
+func newQ(entries Entries) *Q {
+	return (&Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{Entries: entries}}).Go()
+}
+
+func TestConstraints(t *testing.T) {
+	qq := newQ(Entries{
+		Entry{Name: "user", Method: "GET", Path: "/users/:id(int)", Handler: echoParams},
+		Entry{Name: "tag", Method: "GET", Path: "/tags/:tag(alpha,max=5)/x", Handler: echoParams},
+		Entry{Name: "slug", Method: "GET", Path: "/posts/:slug([a-z]*-[0-9]+)", Handler: echoParams},
+		Entry{Name: "uuid", Method: "GET", Path: "/o/:o(uuid)/*rest", Handler: echoParams},
+	})
+	cases := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/users/42", 200, "id=42"},
+		{"/users/abc", 404, ""},
+		{"/tags/abc/x", 200, "tag=abc"},
+		{"/tags/abcdef/x", 404, ""},
+		{"/tags/ab1/x", 404, ""},
+		{"/posts/ab-12", 200, "slug=ab-12"},
+		{"/posts/ab12", 404, ""},
+		{"/o/6ba7b810-9dad-11d1-80b4-00c04fd430c8/a/b", 200, "o=6ba7b810-9dad-11d1-80b4-00c04fd430c8,rest=/a/b"},
+		{"/o/nope/a", 404, ""},
+	}
+	for _, c := range cases {
+		rec := serve(qq, "GET", c.path)
+		if rec.Code != c.code || (c.body != "" && rec.Body.String() != c.body) {
+			t.Errorf("%s: got %d %q", c.path, rec.Code, rec.Body.String())
+		}
+	}
+	if p := qq.Path("user", 5); p != "/users/5" {
+		t.Errorf("path %q", p)
+	}
+	if p := qq.Path("user", "x"); p != "" {
+		t.Errorf("path %q", p)
+	}
+}
+
+func TestConstraintErrors(t *testing.T) {
+	for _, p := range []string{"/a/:id(int", "/a/:id(foo,int)", "/a/:id([)"} {
+		e := &muxEntry{}
+		if err := e.add(p, &route{handlers: Handlers{echoParams}}); err == nil {
+			t.Errorf("expected error for %s", p)
+		}
+	}
+}
+
+func TestConstraintKeywords(t *testing.T) {
+	for _, p := range []string{"/a/:id(integer)", "/a/:id(int,letters)", "/a/:id(min=x)"} {
+		e := &muxEntry{}
+		if err := e.add(p, &route{handlers: Handlers{echoParams}}); err == nil {
+			t.Errorf("expected an unknown keyword error for %s", p)
+		}
+	}
+	qq := newQ(Entries{Entry{Method: "GET", Path: "/a/:id((?:integer))", Handler: echoParams}})
+	if rec := serve(qq, "GET", "/a/integer"); rec.Code != 200 || rec.Body.String() != "id=integer" {
+		t.Errorf("explicit regexp: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestConstraintFallback(t *testing.T) {
+	tagged := func(tag string) Handler {
+		return func(ctx *Context) { io.WriteString(ctx.ResponseWriter, tag+"|"+ctx.Params.String()) }
+	}
+	qq := newQ(Entries{
+		Entry{Method: "GET", Path: "/users/:id(int)", Handler: tagged("id")},
+		// without a constraint, tried last
+		Entry{Method: "GET", Path: "/users/:slug", Handler: tagged("slug")},
+		Entry{Method: "GET", Path: "/users/:name(alpha)/posts", Handler: tagged("name")},
+		Entry{Method: "GET", Path: "/files/:n(int)/raw", Handler: tagged("raw")},
+		Entry{Method: "GET", Path: "/files/:f(min=1)/meta", Handler: tagged("meta")},
+	})
+	cases := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/users/42", 200, "id|id=42"},
+		{"/users/kataras/posts", 200, "name|name=kataras"},
+		{"/users/kataras", 200, "slug|slug=kataras"},
+		{"/users/a-b", 200, "slug|slug=a-b"},
+		{"/users/42/posts", 404, ""},
+		{"/files/5/raw", 200, "raw|n=5"},
+		// the :n(int) matches but its path doesn't, the value falls through to the :f
+		{"/files/5/meta", 200, "meta|f=5"},
+		{"/files/5/nope", 404, ""},
+	}
+	for _, c := range cases {
+		rec := serve(qq, "GET", c.path)
+		if rec.Code != c.code || (c.body != "" && rec.Body.String() != c.body) {
+			t.Errorf("%s: got %d %q", c.path, rec.Code, rec.Body.String())
+		}
+	}
+
+	conflict := &Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{Entries: Entries{
+		Entry{Method: "GET", Path: "/users/:id", Handler: echoParams},
+		Entry{Method: "GET", Path: "/users/:name(alpha)", Handler: echoParams},
+		Entry{Method: "GET", Path: "/users/:name", Handler: echoParams},
+	}}}
+	err := conflict.Build()
+	if err == nil || !strings.Contains(err.Error(), "The parameter: ':name' conflicts with the parameter: ':id'") {
+		t.Fatalf("expected a parameter conflict, got: %v", err)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	qq := newQ(Entries{
+		Entry{Method: "GET", Path: "/users/:id", Handler: echoParams},
+		Entry{Method: "DELETE", Path: "/users/:id", Handler: echoParams},
+		Entry{Method: "POST", Path: "/other", Handler: echoParams},
+	})
+	options := (&Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{AllowMethodOptions: true, Begin: Handlers{func(ctx *Context) { ctx.SetHeader("X-Cors", "1") }}, Entries: Entries{
+		Entry{Method: "GET", Path: "/users/:id", Handler: echoParams},
+	}}}).Go()
+	cases := []struct {
+		qq           *Q
+		method, path string
+		code         int
+		allow, cors  string
+	}{
+		{qq, "PUT", "/users/1", 405, "GET, DELETE", ""},
+		{qq, "OPTIONS", "/users/1", 405, "GET, DELETE", ""},
+		{qq, "GET", "/nope", 404, "", ""},
+		{options, "OPTIONS", "/users/1", 200, "GET, OPTIONS", "1"},
+	}
+	for _, c := range cases {
+		rec := serve(c.qq, c.method, c.path)
+		if rec.Code != c.code || rec.Header().Get("Allow") != c.allow || rec.Header().Get("X-Cors") != c.cors {
+			t.Errorf("%s %s: got %d %v", c.method, c.path, rec.Code, rec.Header())
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package q
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	qq := (&Q{Host: "mydomain.com:80", DisableServer: true,
+		Responses: Responses{Response{Name: "application/x-custom", ContentType: "application/x-custom", Engine: ResponseEngineFunc(func(obj interface{}, o ...map[string]interface{}) ([]byte, error) {
+			return []byte("vnd"), nil
+		})}},
+		Request: Request{Entries: Entries{Entry{Method: "GET", Path: "/", Handler: func(ctx *Context) {
+			ctx.Negotiate("hello")
+		}}}}}).Go()
+	cases := []struct {
+		accept, ctype string
+		code          int
+	}{
+		{"", "application/json", 200},
+		{"application/x-custom", "application/x-custom", 200},
+		{"application/xml;q=0.5, application/json", "application/json", 200},
+		{"application/json;q=0.5, text/xml", "text/xml", 200},
+		{"text/*, */*;q=0.1", "text/xml", 200},
+		{"image/png", "", 406},
+		{"application/json;q=0, */*;q=0.1", "text/xml", 200},
+		{"text/markdown", "text/html", 200},
+	}
+	for _, c := range cases {
+		rec := serve(qq, "GET", "/", "Accept", c.accept)
+		if rec.Code != c.code || !strings.HasPrefix(rec.Header().Get("Content-Type"), c.ctype) || rec.Header().Get("Vary") != "Accept" {
+			t.Errorf("%q: %d %q %v", c.accept, rec.Code, rec.Header().Get("Content-Type"), rec.Header())
+		}
+	}
+}
+
+type negotiateUser struct {
+	ID   int    `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name"`
+}
+
+func TestNegotiateStruct(t *testing.T) {
+	qq := newQ(Entries{Entry{Method: "GET", Path: "/", Handler: func(ctx *Context) {
+		ctx.Negotiate(negotiateUser{ID: 1, Name: "kataras"})
+	}}})
+	cases := []struct {
+		accept, ctype string
+		code          int
+	}{
+		// a browser, the markdown engine, which is rendered as text/html, can't render a struct
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "application/json", 200},
+		{"text/html, text/xml;q=0.9", "text/xml", 200},
+		{"text/html, */*;q=0.8", "application/json", 200},
+		{"text/plain", "", 406},
+		{"text/markdown", "", 406},
+		{"application/octet-stream", "", 406},
+		{"", "application/json", 200},
+	}
+	for _, c := range cases {
+		rec := serve(qq, "GET", "/", "Accept", c.accept)
+		if rec.Code != c.code || !strings.HasPrefix(rec.Header().Get("Content-Type"), c.ctype) {
+			t.Errorf("%q: %d %q %q", c.accept, rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+		}
+	}
+}
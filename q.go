@@ -45,11 +45,16 @@ package q
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/kataras/q/errors"
 )
 
 const (
@@ -84,19 +89,29 @@ type Q struct {
 	CertFile, KeyFile string
 	// if true then the .Go is not listens and serves, it prepares the net/http handler to be used inside a custom handler, the Host should be given in any case for smooth experience.
 	DisableServer bool
-	listener      *ServerListener // the only reason it's exists as field is to be able to close the http(net) listener
+	// TrustedProxies are the IPs or the CIDRs, i.e '10.0.0.0/8', of the proxies, i.e the load balancers, in front of q.
+	// The Forwarded (RFC 7239), the X-Forwarded-For, the X-Forwarded-Proto, the X-Forwarded-Host and the X-Real-Ip headers are trusted only if they're sent by one of them,
+	// look ctx.RemoteAddr, ctx.Scheme and ctx.Host. Empty for none, the headers are ignored
+	TrustedProxies []string
+	trustedProxies []*net.IPNet
+	listener       *ServerListener // the only reason it's exists as field is to be able to close the http(net) listener
 	// end http server
 
 	// Charset used to render/send responses to the client
 	Charset string
-	// If true then templates and response engines will be rendered using Gzip compression,
-	// you can still disable each render's gzip with: q.RenderOptions{"gzip": false} on the context.Render func
+	// If true then templates and response engines will be rendered using compression, by the encoder which the client prefers, look the Compression,
+	// you can still disable each render's compression with: q.RenderOptions{"gzip": false} on the context.Render func
 	Gzip bool
+	// Compression is the configuration of the compression, the encoders, the minimum size and the content types of the compressed responses,
+	// it's used by the Gzip, the Dir{Gzip: true} and the Compress middleware
+	Compression Compression
 	// If true then you get some logs on specific cases, only for errors mostly.
 	DevMode bool
 	// TimeFormat default time format for any kind of datetime parsing
 	TimeFormat string
 	// StaticCacheDuration expiration duration for INACTIVE file handlers
+	//
+	// Deprecated: the static handlers validate the client's cache by the ETag and the Last-Modified headers, it's not used anymore
 	StaticCacheDuration time.Duration
 
 	Logger     *log.Logger
@@ -108,13 +123,37 @@ type Q struct {
 	responses  *responseEngines
 	Session    Session
 	sessions   *sessionsManager
+	cookies    *cookieCodec
 	Websockets Websockets
 	SSH        SSH
 	Tester     Tester
+	// built is the builded instance, setted by the Build, used by the Go
+	built *Q
+}
+
+// BuildErrors is the error which is returned by the Q.Build, it contains all the build's errors,
+// i.e an EntryError for each entry which couldn't be registered
+type BuildErrors []error
+
+// Error returns the messages of all errors, one per line
+func (errs BuildErrors) Error() string {
+	msg := ""
+	for i, err := range errs {
+		if i > 0 {
+			msg += "\n"
+		}
+		msg += strings.TrimSpace(err.Error())
+	}
+	return msg
 }
 
-// builder
-func (q *Q) build() {
+// Build builds the Q instance, the templates, the responses, the sessions, the router and the SSH commands,
+// it doesn't start the server, so the caller can decide whether to start serving, by the .Go, or not.
+// Returns the BuildErrors, all the template's and the entries' errors at once, i.e the conflicts between the entries.
+//
+// The .Go calls the Build if not called before.
+func (q *Q) Build() error {
+	var errs BuildErrors
 	q.Host = parseHost(q.Host)
 
 	if q.TimeFormat == "" {
@@ -125,6 +164,14 @@ func (q *Q) build() {
 		q.StaticCacheDuration = 20 * time.Second
 	}
 
+	q.Compression.build()
+
+	if trustedProxies, err := parseTrustedProxies(q.TrustedProxies); err != nil {
+		errs = append(errs, err)
+	} else {
+		q.trustedProxies = trustedProxies
+	}
+
 	// logger
 	if q.Logger == nil {
 		q.Logger = log.New(os.Stdout, "[Q] ", log.LstdFlags)
@@ -133,7 +180,7 @@ func (q *Q) build() {
 	// events
 	q.EventEmmiter = &eventEmmiter{}
 	q.Events.copyTo(q.EventEmmiter)
-	q.Emit("build", q) // the one and only built'n event
+	q.Emit("build", q) // built'n event, the 'panic' is the other one, look .ServeHTTP
 
 	// templates
 	q.templates = &templateEngines{
@@ -144,7 +191,9 @@ func (q *Q) build() {
 		reload: q.DevMode,
 	}
 
-	q.Templates.loadTo(q.templates)
+	if err := q.Templates.loadTo(q.templates); err != nil {
+		errs = append(errs, err)
+	}
 
 	// responses
 	q.responses = &responseEngines{}
@@ -154,13 +203,33 @@ func (q *Q) build() {
 	q.Websockets.copyTo(&q.Request.Entries)
 
 	// sessions
-	q.sessions = q.Session.newManager()
+	if err := q.Session.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if cookies, err := newCookieCodec(q.Session.Keys, q.Session.Encrypt); err != nil {
+		errs = append(errs, err)
+	} else {
+		q.cookies = cookies
+	}
+	q.sessions = q.Session.newManager(q.Logger, q.EventEmmiter)
 
 	// request & handler
-	q.Request.build(q.Host)
+	if err := q.Request.build(q.Host); err != nil {
+		if entryErrs, ok := err.(BuildErrors); ok {
+			errs = append(errs, entryErrs...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
 
-	// SSH (builds the commands and starts the ssh server (if DisableServer is false) (yes, before the http server))
+	// SSH (builds the commands, the ssh server is started by the .Go)
 	q.SSH.bindTo(q)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	q.built = q
+	return nil
 }
 
 func (q *Q) runServer() error {
@@ -190,29 +259,71 @@ func (q *Q) must(err error) {
 	}
 }
 
-// Go builds [and runs the server]
-// returns itself
+// Go builds, if not builded by the .Build before, [and runs the server]
+// panics if the build fails, use the .Build to handle the build's errors
+// returns itself, the builded instance
 func (q Q) Go() *Q {
-	q.build()
-	if !q.DisableServer {
+	p := &q
+	if q.built != nil {
+		// the Build is called before, serve that instance
+		p = q.built
+	} else if err := p.Build(); err != nil {
+		p.Logger.Panic(err)
+	}
+	if !p.DisableServer {
+		// start the ssh server (yes, before the http server)
+		if p.SSH.Enabled() && !p.SSH.IsListening() {
+			go func() {
+				p.must(p.SSH.Listen())
+			}()
+		}
 		//	q.must(q.runServer())
-		err := q.runServer()
+		err := p.runServer()
 		if err != nil {
-			if q.SSH.IsListening() && strings.Contains(err.Error(), "use of closed network connection") { // propably manually restart
+			if p.SSH.IsListening() && strings.Contains(err.Error(), "use of closed network connection") { // propably manually restart
 				ch := make(chan os.Signal)
 				<-ch
 			} else {
-				q.Logger.Panic(err)
+				p.Logger.Panic(err)
 			}
 		}
 	}
-	return &q
+	return p
 }
 
+// ServeHTTP serves the request by the Request.Handler,
+// a panic in any handler is recovered, logged with its stack and sent to the 'panic' event's listeners and the 500's error handler, look .recoverPanic
 func (q *Q) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	ctx := q.acquireCtx(res, req)
+	defer q.releaseCtx(ctx)
+	defer q.recoverPanic(ctx)
 	q.Request.Handler(ctx)
-	q.releaseCtx(ctx)
+}
+
+// recoverPanic recovers from a panic of the request's handlers, logs the recovered value with the stack by the Logger,
+// emits the 'panic' event with the context, the recovered value and the stack as data
+// and calls the StatusInternalServerError's error handler, the recovered value is available by the ctx.Recovered().
+// The error handler is not called if the headers are sent already and the http.ErrAbortHandler is not recovered,
+// it's the net/http's way to abort a response.
+func (q *Q) recoverPanic(ctx *Context) {
+	err := recover()
+	if err == nil {
+		return
+	}
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+	stack := debug.Stack()
+	q.Logger.Printf("Recovered from a panic while serving %s %s: %v\n%s", ctx.Request.Method, ctx.Request.URL.Path, err, stack)
+	ctx.Set(panicContextKey, err)
+	// discard the buffered response of the handlers, if any, the error handler writes its own
+	ctx.ResponseWriter.SetBody(nil)
+	q.Emit("panic", ctx, err, stack)
+	if ctx.ResponseWriter.Written() {
+		// the status code and a part of the body are sent, the error handler can't replace them
+		return
+	}
+	ctx.EmitError(StatusInternalServerError)
 }
 
 func (q *Q) acquireCtx(res http.ResponseWriter, req *http.Request) *Context {
@@ -220,25 +331,39 @@ func (q *Q) acquireCtx(res http.ResponseWriter, req *http.Request) *Context {
 	var ctx *Context
 	if v == nil {
 		ctx = &Context{
-			ResponseWriter: res,
-			Request:        req,
-			q:              q,
+			Request: req,
+			q:       q,
 		}
 	} else {
 		ctx = v.(*Context)
 		ctx.Params = ctx.Params[0:0]
-		ctx.ResponseWriter = res
 		ctx.Request = req
 		ctx.values.Reset()
 		ctx.handlers = nil
 		ctx.pos = 0
 		ctx.session = nil
+		ctx.errors = nil
+		ctx.usePos = 0
+		ctx.entry = nil
+		ctx.sse = nil
+		ctx.compressor = nil
 	}
+	ctx.writer.reset(res)
+	ctx.ResponseWriter = &ctx.writer
 
 	return ctx
 }
 
 func (q *Q) releaseCtx(ctx *Context) {
+	if ctx.sse != nil {
+		ctx.sse.close()
+	}
+	if ctx.compressor != nil {
+		ctx.compressor.close()
+	}
+	ctx.removeUploads()
+	// send the buffered response, if any
+	ctx.writer.flushBuffer()
 	q.Request.contextPool.Put(ctx)
 }
 
@@ -267,8 +392,14 @@ func (q *Q) ResponseString(keyOrContentType string, obj interface{}, options ...
 
 // Path used to check arguments with the route's named parameters and return the correct url
 // if parse failed returns empty string
+// if the arguments are a Map and/or url.Values then it's the same as the .Reverse, the error is ignored
 func (q *Q) Path(routeName string, args ...interface{}) string {
-	r := q.Request.mux.lookup(routeName)
+	if params, query, ok := reverseArgs(args); ok {
+		path, _ := q.Reverse(routeName, params, query)
+		return path
+	}
+
+	r := q.lookup(routeName)
 	if r == nil {
 		return ""
 	}
@@ -279,8 +410,8 @@ func (q *Q) Path(routeName string, args ...interface{}) string {
 	if argsLen == 0 && r.formattedParts > 0 {
 		return ""
 	} else if argsLen == 0 && r.formattedParts == 0 {
-		// it's static then just return the path
-		return r.path
+		// it's static then just return the escaped path
+		return escapePath(r.path)
 	}
 
 	// we have arguments but they are much more than the named parameters
@@ -332,27 +463,30 @@ func (q *Q) Path(routeName string, args ...interface{}) string {
 		}
 	}
 
+	// refuse to build a path which the route can't serve
+	if !r.validArgs(arguments) {
+		return ""
+	}
+
 	return fmt.Sprintf(r.formattedPath, arguments...)
 }
 
 // URL returns the subdomain+ host + Path(...optional named parameters if route is dynamic)
 // returns an empty string if parse is failed
+// if the arguments are a Map and/or url.Values then it's the same as the .ReverseURL, the error is ignored
 func (q *Q) URL(routeName string, args ...interface{}) (url string) {
-	r := q.Request.mux.lookup(routeName)
-	if r == nil {
+	if params, query, ok := reverseArgs(args); ok {
+		url, _ = q.ReverseURL(routeName, params, query)
 		return
 	}
 
-	if q.Scheme == "" {
-		if (q.CertFile != "" && q.KeyFile != "") || parsePort(q.Host) == 443 || q.Host == ":https" {
-			q.Scheme = schemeHTTPS
-		} else {
-			q.Scheme = schemeHTTP
-		}
+	r := q.lookup(routeName)
+	if r == nil {
+		return
 	}
 
-	scheme := q.Scheme
-	host := q.Host
+	scheme := q.scheme()
+	host, isDynamic := q.routeHost(r)
 	arguments := args[0:]
 
 	// join arrays as arguments
@@ -370,25 +504,148 @@ func (q *Q) URL(routeName string, args ...interface{}) (url string) {
 		}
 	}
 
-	// if it's dynamic subdomain then the first argument is the subdomain part
-	if r.subdomain == dynamicSubdomainIndicator {
-		if len(arguments) == 0 { // it's a wildcard subdomain but not arguments
-			return
+	// if it's dynamic subdomain then the first arguments are the subdomain's parts
+	if isDynamic {
+		if r.host != "" {
+			// wildcard host, the first argument is the subdomain part
+			if len(arguments) == 0 {
+				return
+			}
+			subdomain, ok := arguments[0].(string)
+			if !ok {
+				// it is not array because we join them before. if not pass a string then this is not a subdomain part, return empty uri
+				return
+			}
+			host = subdomain + "." + host
+			arguments = arguments[1:]
+		} else {
+			// the wildcards and the named parameters, by their order or by their names if a Map is the first argument
+			subdomain, rest, ok := formatSubdomain(r.subdomain, arguments)
+			if !ok {
+				return
+			}
+			host = subdomain + host
+			arguments = rest
 		}
+	}
+
+	if parsedPath := q.Path(routeName, arguments...); parsedPath != "" {
+		url = scheme + host + parsedPath
+	}
+
+	return
+}
+
+var errReverseEntryNotFound = errors.New("Reverse: entry with name: '%s' couldn't be found!")
+
+// Reverse returns the path of an entry, found by its name, with the named parameters' values from the params, by their names, and the query, if any.
+// i.e: myQ.Reverse("user", q.Map{"id": 42}, url.Values{"tab": {"posts"}}) returns '/users/42?tab=posts'
+//
+// The values can be strings, bools, any integer or float type, fmt.Stringer and []string for the match everything parameter ('*path'), they are escaped.
+// Returns an error if the entry couldn't be found, a parameter's value is missing, has an unsupported type or doesn't match with the parameter's constraint.
+func (q *Q) Reverse(routeName string, params Map, query url.Values) (string, error) {
+	r := q.lookup(routeName)
+	if r == nil {
+		return "", errReverseEntryNotFound.Format(routeName)
+	}
+	return r.reverse(params, query)
+}
+
+// ReverseURL same as .Reverse but returns the full url, the scheme, the host and the path,
+// the values of the subdomain's named parameters are taken from the params too and the params["*"] is the value of the wildcard subdomain, if any.
+func (q *Q) ReverseURL(routeName string, params Map, query url.Values) (string, error) {
+	r := q.lookup(routeName)
+	if r == nil {
+		return "", errReverseEntryNotFound.Format(routeName)
+	}
 
-		if subdomain, ok := arguments[0].(string); ok {
+	host, isDynamic := q.routeHost(r)
+	if isDynamic {
+		if r.host != "" {
+			// wildcard host
+			subdomain, err := r.reverseParam("*", params)
+			if err != nil {
+				return "", err
+			}
 			host = subdomain + "." + host
 		} else {
-			// it is not array because we join them before. if not pass a string then this is not a subdomain part, return empty uri
-			return
+			subdomain, err := r.reverseSubdomain(params)
+			if err != nil {
+				return "", err
+			}
+			host = subdomain + host
 		}
+	}
 
-		arguments = arguments[1:]
+	path, err := r.reverse(params, query)
+	if err != nil {
+		return "", err
 	}
+	return q.scheme() + host + path, nil
+}
 
-	if parsedPath := q.Path(routeName, arguments...); parsedPath != "" {
-		url = scheme + host + parsedPath
+// reverseArgs returns true if the arguments are a Map and/or url.Values, in any order, they are passed to the .Reverse or .ReverseURL
+func reverseArgs(args []interface{}) (params Map, query url.Values, ok bool) {
+	if len(args) == 0 || len(args) > 2 {
+		return
+	}
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case Map:
+			params = v
+		case map[string]interface{}:
+			params = v
+		case url.Values:
+			query = v
+		default:
+			return nil, nil, false
+		}
+	}
+	return params, query, true
+}
+
+// lookup returns the route by its name, or the mounted Q instances' route with the mount's prefix, nil if not found or the Q's router is not used
+func (q *Q) lookup(routeName string) *route {
+	if q.Request.mux == nil {
+		return nil
+	}
+	if r := q.Request.mux.lookup(routeName); r != nil {
+		return r
 	}
+	return q.Request.mux.lookupMounted(routeName)
+}
 
+// scheme returns the Q.Scheme, it's setted by the Q's host and certificates if empty
+func (q *Q) scheme() string {
+	if q.Scheme == "" {
+		if (q.CertFile != "" && q.KeyFile != "") || parsePort(q.Host) == 443 || q.Host == ":https" {
+			q.Scheme = schemeHTTPS
+		} else {
+			q.Scheme = schemeHTTP
+		}
+	}
+	return q.Scheme
+}
+
+// routeHost returns the host of the route and true if the route has a dynamic subdomain or a wildcard host,
+// in that case the host doesn't contain the dynamic part, i.e the '*.example.org' returns 'example.org'
+func (q *Q) routeHost(r *route) (host string, isDynamic bool) {
+	host = q.Host
+	isDynamic = isDynamicSubdomain(r.subdomain)
+	if r.host != "" {
+		host = r.host
+		if strings.HasPrefix(host, dynamicSubdomainIndicator) {
+			host = host[len(dynamicSubdomainIndicator):]
+			isDynamic = true
+		}
+		// the host without port is served by the Q.Host's port
+		if strings.IndexByte(host, ':') == -1 {
+			if port := parsePort(q.Host); port != 80 && port != 443 {
+				host += ":" + strconv.Itoa(port)
+			}
+		}
+	} else if r.subdomain != "" && !isDynamic {
+		host = r.subdomain + host
+	}
 	return
 }
@@ -0,0 +1,165 @@
+package q
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildErrors(t *testing.T) {
+	qq := &Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{Entries: Entries{
+		{Method: "GET", Path: "/users/:id", Name: "user", Handler: echoParams},
+		{Method: "GET", Path: "/users/:name", Name: "user-by-name", Handler: echoParams},
+		{Method: "GET", Path: "/a", Handler: echoParams},
+		{Method: "GET", Path: "/a", Handler: echoParams},
+		{Method: "GET", Path: "/nohandler"},
+	}}}
+	err := qq.Build()
+	errs, ok := err.(BuildErrors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("%#v", err)
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "'user-by-name' GET /users/:name") || !strings.Contains(msg, "'<unnamed>' GET /a") {
+		t.Error(msg)
+	}
+
+	ok2 := &Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{Entries: Entries{{Method: "GET", Path: "/", Handler: writeS("x")}}}}
+	if err := ok2.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if p := ok2.Go(); p != ok2 {
+		t.Fatal("not the same instance")
+	}
+	if rec := serve(ok2, "GET", "/"); rec.Body.String() != "x" {
+		t.Fatal(rec.Body.String())
+	}
+}
+
+func TestReverse(t *testing.T) {
+	qq := newQ(Entries{
+		{Method: "GET", Path: "/users/:id(int)/posts/:slug", Name: "post", Handler: echoParams},
+		{Method: "GET", Path: "/static/*filepath", Name: "static", Handler: echoParams},
+		{Path: ":tenant.", Entries: Entries{{Method: "GET", Path: "/p/:f", Name: "tp", Handler: echoParams}}},
+		{Method: "GET", Path: "/100%", Name: "pct", Handler: echoParams},
+		{Method: "GET", Path: "/a b/:id", Name: "space", Handler: echoParams},
+	})
+	cases := []struct {
+		name   string
+		values Map
+		query  url.Values
+		want   string
+		err    string
+	}{
+		{"post", Map{"id": int64(42), "slug": "a b/c"}, url.Values{"tab": {"x y"}}, "/users/42/posts/a%20b%2Fc?tab=x+y", ""},
+		{"post", Map{"id": 42}, nil, "", "'slug' of the entry: 'post' is missing"},
+		{"post", Map{"id": "x", "slug": "s"}, nil, "", "doesn't match with the parameter's constraint"},
+		{"post", Map{"id": 1, "slug": struct{}{}}, nil, "", "unsupported type: struct {}"},
+		{"post", Map{"id": uint8(1), "slug": time.Second}, nil, "/users/1/posts/1s", ""},
+		{"post", Map{"id": 1, "slug": 1.5}, nil, "/users/1/posts/1.5", ""},
+		{"static", Map{"filepath": []string{"css", "a b.css"}}, nil, "/static/css/a%20b.css", ""},
+		{"pct", nil, nil, "/100%25", ""},
+		{"space", Map{"id": 1}, nil, "/a%20b/1", ""},
+	}
+	for _, c := range cases {
+		p, err := qq.Reverse(c.name, c.values, c.query)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s %v: expected an error, got %q %v", c.name, c.values, p, err)
+			}
+			continue
+		}
+		if err != nil || p != c.want {
+			t.Errorf("%s %v: got %q %v", c.name, c.values, p, err)
+		}
+	}
+	if p := qq.Path("static", "css/main.css"); p != "/static/css/main.css" {
+		t.Error(p)
+	}
+	if p := qq.Path("pct"); p != "/100%25" {
+		t.Error(p)
+	}
+	if rec := serve(qq, "GET", "/100%25"); rec.Code != 200 {
+		t.Errorf("the reversed path is not served: %d", rec.Code)
+	}
+	if u, err := qq.ReverseURL("tp", Map{"tenant": "acme", "f": "x"}, nil); u != "http://acme.mydomain.com:80/p/x" {
+		t.Error(u, err)
+	}
+	if u := qq.URL("tp", Map{"tenant": "acme", "f": "x"}); u != "http://acme.mydomain.com:80/p/x" {
+		t.Error(u)
+	}
+	if p := qq.Path("post", 3, "s"); p != "/users/3/posts/s" {
+		t.Error(p)
+	}
+	tmpl := template.Must(template.New("").Funcs(template.FuncMap{"urlpath": qq.Path}).Parse(`{{ urlpath "post" .P .Q }}`))
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, map[string]interface{}{"P": Map{"id": 5, "slug": "z"}, "Q": url.Values{"a": {"1"}}}); err != nil || b.String() != "/users/5/posts/z?a=1" {
+		t.Error(b.String(), err)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	var logs bytes.Buffer
+	var evt []interface{}
+	qq := (&Q{Host: "mydomain.com:80", DisableServer: true, Logger: log.New(&logs, "", 0),
+		Events: Events{"panic": EventListeners{func(data ...interface{}) { evt = data }}},
+		Request: Request{
+			Errors: Errors{500: func(ctx *Context) {
+				ctx.SetStatusCode(500)
+				io.WriteString(ctx.ResponseWriter, "oops:"+ctx.Recovered().(string))
+			}},
+			Entries: Entries{
+				Entry{Method: "GET", Path: "/boom", Handler: func(ctx *Context) { panic("boom") }},
+				Entry{Method: "GET", Path: "/buffered", Handler: func(ctx *Context) {
+					ctx.ResponseWriter.Buffer()
+					io.WriteString(ctx.ResponseWriter, "partial")
+					panic("buffered")
+				}},
+				Entry{Method: "GET", Path: "/sent", Handler: func(ctx *Context) {
+					io.WriteString(ctx.ResponseWriter, "partial")
+					panic("sent")
+				}},
+				Entry{Method: "GET", Path: "/abort", Handler: func(ctx *Context) { panic(http.ErrAbortHandler) }},
+			},
+		}}).Go()
+	cases := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/boom", 500, "oops:boom"},
+		{"/buffered", 500, "oops:buffered"},
+		// the headers are sent, the error handler is not called
+		{"/sent", 200, "partial"},
+	}
+	for _, c := range cases {
+		logs.Reset()
+		evt = nil
+		rec := serve(qq, "GET", c.path)
+		if rec.Code != c.code || rec.Body.String() != c.body {
+			t.Errorf("%s: %d %q", c.path, rec.Code, rec.Body.String())
+		}
+		if recovered := c.path[1:]; !strings.Contains(logs.String(), "q_test.go") || len(evt) != 3 || evt[1] != recovered {
+			t.Errorf("%s: log %q evt %v", c.path, logs.String(), evt)
+		}
+	}
+
+	logs.Reset()
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("the http.ErrAbortHandler is recovered: %v", err)
+			}
+		}()
+		serve(qq, "GET", "/abort")
+	}()
+	if logs.Len() != 0 {
+		t.Errorf("the http.ErrAbortHandler is logged: %q", logs.String())
+	}
+}
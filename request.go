@@ -1,13 +1,23 @@
 package q
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"html"
 	"io"
+	"net/http"
 	"net/http/pprof"
+	"net/url"
+	"reflect"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/kataras/q/errors"
 )
 
 // Entries TODO:
@@ -23,7 +33,7 @@ func (entries *Entries) Add(entr ...Entry) {
 
 // ByName return an entry by it's name
 //
-// Note: It doesn't return the same thing as Request.GetEntr which returns CompiledEntry or Request.mux.lookup which returns *route, this is for unregistered entries, before .registerEntry on mux.
+// Note: It doesn't return the same thing as Request.GetEntry which returns CompiledEntry or Request.mux.lookup which returns *route, this is for unregistered entries, before .compileEntry on mux.
 // used internaly by Q.
 func (entries *Entries) ByName(entryName string) *Entry {
 	pEntries := *entries
@@ -50,6 +60,8 @@ type Entry struct {
 	Method string
 	Head   bool // set to true if you want this entry to be valid on HEAD http method also, defaults to false, useful when the entry serves static files
 	// The request path
+	// it can start with a subdomain, i.e 'api.', or a full host, i.e 'example.org/', '*.example.org/' or 'example.org:8080/',
+	// the full host's port is optional, if not given then the entry is served on any port
 	Path string // if empty then this will be available using all http methods
 	// Middleware before Handler
 	Begin Handlers
@@ -59,11 +71,61 @@ type Entry struct {
 	Handler Handler
 	// Any children entries, use it to group routes with the same prefix and middleware
 	Entries Entries
+	// Errors, optional, the custom http errors handlers of a full host entry and its children, i.e 'example.org/',
+	// the requests to that host which don't match with an entry are handled by these, the missing status codes are handled by the Request.Errors
+	Errors Errors
+	// Mount, optional, serves the requests to the Path and anything under it by another Q instance or any http.Handler, the Path prefix is stripped,
+	// the Begin and Done, the entry's and its parents', run around it, the Handler is ignored.
+	// A mounted Q instance keeps its own templates, sessions and errors, it's builded by the parent if not builded before,
+	// its named entries are resolved by the parent's .URL/.Path/.Reverse, with the Path prefix.
+	Mount http.Handler
+	// Timeout, optional, the maximum duration of the entry's handlers, the Request.Begin & Done too, the children entries inherit it.
+	// The ctx.Done is closed when it's passed, the response of the handlers is discarded and the TimeoutStatus' error handler of the Request.Errors is emitted instead.
+	// The response is buffered until the handlers finish, so don't use it on entries which stream their response.
+	Timeout time.Duration
+	// TimeoutStatus, optional, the http status code which is emitted when the Timeout is passed, i.e StatusGatewayTimeout, defaults to StatusServiceUnavailable
+	TimeoutStatus int
 	// Parser is the method which can be used to change the fields of a user-defined Entry
 	// look fs.go for more
 	Parser EntryParser
 	// subdomains is a compiled private field, used to map the entry with specific subdomains, inside mux
 	subdomain string
+	// host is a compiled private field, used to map the entry with a full host, inside mux
+	host string
+}
+
+// parseHost moves the subdomain or the full host part of the Path to the subdomain or the host field,
+// 'api.', 'api./path', 'example.org/' or 'example.org/path'
+func (e *Entry) parseHost() {
+	if len(e.Path) == 0 || e.Path[0] == '/' {
+		return
+	}
+	hostPart, path := e.Path, ""
+	if idx := strings.IndexByte(e.Path, '/'); idx > 0 {
+		hostPart, path = e.Path[0:idx], e.Path[idx:]
+	} else if e.Path[len(e.Path)-1] != '.' {
+		// not a subdomain or a host
+		return
+	}
+
+	// only subdomains ends with '.'
+	if hostPart[len(hostPart)-1] == '.' {
+		if e.host != "" {
+			// a subdomain of a full host, it's a full host too
+			e.host = hostPart + e.host
+		} else {
+			e.subdomain = hostPart + e.subdomain
+		}
+	} else {
+		e.host = strings.ToLower(hostPart)
+		e.subdomain = ""
+	}
+
+	if path == slash {
+		// the children's paths start with slash
+		path = ""
+	}
+	e.Path = path
 }
 
 // doParse returns the converted Entry from the Parser or itself if Parser is nil
@@ -79,19 +141,46 @@ func (e Entry) doParse() Entry {
 // it's map[int]Handler
 type Errors map[int]Handler
 
+// merge returns the errors with the children's errors, the children's handlers override the parent's
+func (errs Errors) merge(children Errors) Errors {
+	if len(children) == 0 {
+		return errs
+	}
+	if len(errs) == 0 {
+		return children
+	}
+	merged := make(Errors, len(errs)+len(children))
+	for statusCode, h := range errs {
+		merged[statusCode] = h
+	}
+	for statusCode, h := range children {
+		merged[statusCode] = h
+	}
+	return merged
+}
+
 // Request the iteral which keeps all router's configuration, register routes/entries, set middleware with Begin & Done & set custom http errors with the Errors field:
 type Request struct {
 	DisablePathCorrection bool
 	DisablePathEscape     bool
-	// AllowMethodOptions if setted to true to allow all routes to be served to the client when http method 'OPTIONS', useful when user uses the Cors middleware
+	// AllowMethodOptions if setted to true then the OPTIONS requests to a registered path, which has not an OPTIONS entry,
+	// are answered automatically with the 'Allow' header, the Request.Begin & Done handlers are executed also, useful when user uses the Cors middleware.
+	// If false then these requests are answered with the 405 Method Not Allowed, like any other not registered http method.
 	// defaults to false
 	AllowMethodOptions bool
+	// MaxBodySize is the maximum bytes of the request body which are read by the ctx.Bind, FormFile and Uploads, defaults to DefaultMaxBodySize, 32MB
+	MaxBodySize int64
 	// Custom http errors handlers
 	Errors Errors
 	// Middleware before any entry's main handler
 	Begin Handlers
 	// Middleware after any entry's main handler
 	Done Handlers
+	// Use, middleware which run on every request before the routing, even if the request is not served by an entry, i.e 404 and 405 and their Errors' handlers.
+	// They run one after the other, like the Begin, a middleware can call the ctx.Next to run the rest middleware and the router and continue after them,
+	// i.e to log the response's status code, the ctx.StopExecution stops the request before the routing.
+	// The ctx.Entry returns the CompiledEntry which served the request, after the routing, if any.
+	Use Handlers
 	// if !=nil then this is used for the main router
 	// if !=nil then the .Entry/.Entries,context.RedirectTo & all q's static handler will not work, you have to build them by yourself.
 	Handler Handler
@@ -100,9 +189,16 @@ type Request struct {
 	//
 	mux         *serveMux // can be nil if Handler is setted by user
 	contextPool sync.Pool
+	// errors keeps the Errors which are read by the ctx.EmitError, they're never changed, a copy is published by the updateErrors when they're changed at runtime
+	errors atomic.Value
+	// errorsMu serializes the writers of the errors
+	errorsMu sync.Mutex
+	// router is the Handler which is wrapped by the Use middleware, called by the ctx.Next
+	router Handler
 }
 
-func (req *Request) build(host string) {
+// build builds the router and the Handler, returns the BuildErrors of the entries which couldn't be registered
+func (req *Request) build(host string) error {
 	if req.Errors == nil {
 		req.Errors = make(map[int]Handler, 0)
 	}
@@ -125,91 +221,462 @@ func (req *Request) build(host string) {
 		// make use of the q's default mux
 		req.mux = newServeMux()
 		for i := range req.Entries {
-			req.registerEntry(req.Entries[i])
+			req.mux.register(req.compileEntry(req.Entries[i])...)
+		}
+		if err := req.mux.build(); err != nil {
+			return err
+		}
+		if err := req.buildMounts(req.mux.routes()); err != nil {
+			return err
+		}
+		if req.AllowMethodOptions {
+			req.mux.optionsHandlers = req.buildOptionsHandlers()
 		}
-		req.mux.allowOptions = req.AllowMethodOptions
 		// we set & build the handler, to the buildHandler whcich is called at the end of build all, because the mux is useful on other Q's internally components, like websocket
 		req.Handler = req.mux.Handler(!req.DisablePathEscape, !req.DisablePathCorrection, host)
 	}
+
+	req.errors.Store(req.Errors)
+
+	if len(req.Use) > 0 {
+		// the Use middleware run before the router, the router is called by the last ctx.Next
+		req.router = req.Handler
+		req.Handler = func(ctx *Context) {
+			ctx.Next()
+		}
+	}
+	return nil
+}
+
+// errorHandlers returns the Errors which serve the requests, the ones of the build or the last updateErrors
+func (req *Request) errorHandlers() Errors {
+	if errs, ok := req.errors.Load().(Errors); ok {
+		return errs
+	}
+	return req.Errors
 }
 
-// no need to change anything inside user-defined entries, we change them and register the entry immediatly
-func (req *Request) registerEntry(e Entry) {
+// updateErrors is used to change the Errors of a running Q,
+// it passes a copy of the current Errors to the 'change' func and publishes its result, the requests are served by the new Errors immediately
+func (req *Request) updateErrors(change func(Errors) Errors) {
+	req.errorsMu.Lock()
+	defer req.errorsMu.Unlock()
+	current := req.errorHandlers()
+	errs := make(Errors, len(current))
+	for statusCode, h := range current {
+		errs[statusCode] = h
+	}
+	req.errors.Store(change(errs))
+}
+
+// no need to change anything inside user-defined entries, we change them and compile the entry immediatly
+// compileEntry returns the routes of the entry and its children entries, these should be registered to the mux
+func (req *Request) compileEntry(e Entry) []*route {
 	entry := e.doParse()
+	entry.parseHost()
 	if len(entry.Entries) > 0 {
-		// subdomain or party
+		var routes []*route
+		// subdomain, host or party
 		for i := range entry.Entries {
 			r := entry.Entries[i].doParse()
-
-			subdomain := ""
-			// check it's path
-			// only subdomains ends with '.'
-			if entry.Path[len(entry.Path)-1] == '.' {
-				subdomain = entry.Path[0:strings.LastIndexByte(entry.Path, '.')+1] + entry.subdomain // +1 because we need the, +entry.subdomain to be able for children unlimited subdomains.
-			}
-
 			r.Method = parseMethod(r.Method)
-			r.subdomain = subdomain
+			// children unlimited subdomains and parties, a child's subdomain is added in front of the parent's subdomain or host by its .parseHost
+			r.subdomain = entry.subdomain
+			r.host = entry.host
 			// set the begin,done, first parent's after children's
 			r.Begin = append(entry.Begin, r.Begin...)
 			r.Done = append(entry.Done, r.Done...)
-			if subdomain == "" {
-				// it's party, then set the full path also
-				r.Path = entry.Path + r.Path // do not use the filepath package here, because if the dev has CorrectPath false this will break the last slash
+			r.Errors = entry.Errors.merge(r.Errors)
+			if r.Timeout == 0 {
+				r.Timeout = entry.Timeout
+			}
+			if r.TimeoutStatus == 0 {
+				r.TimeoutStatus = entry.TimeoutStatus
 			}
-			req.registerEntry(r)
+			// set the full path also, the subdomain and host parts are not part of the entry.Path here
+			r.Path = entry.Path + r.Path // do not use the filepath package here, because if the dev has CorrectPath false this will break the last slash
+			routes = append(routes, req.compileEntry(r)...)
 		}
-		return
+		return routes
 	}
 
-	handlersLen := len(req.Begin) + len(entry.Begin) + len(entry.Done) + len(req.Done)
-	if entry.Handler != nil {
-		handlersLen++
+	path := entry.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+	paths := []string{path}
+
+	var mainHandlers Handlers
+	mountPrefix := ""
+	if entry.Mount != nil {
+		// serve the prefix and anything under it
+		mountPrefix = strings.TrimSuffix(path, slash)
+		paths = []string{mountPrefix + slash + string(matchEverythingByte) + mountParamName}
+		if mountPrefix != "" {
+			paths = append(paths, mountPrefix)
+		}
+		mainHandlers = Handlers{mountHandler(entry.Mount)}
+	} else if entry.Handler != nil {
+		mainHandlers = Handlers{entry.Handler}
 	}
-	handlers := make(Handlers, handlersLen, handlersLen)
-	j := 0
+	handlers := withTimeout(req.wrapHandlers(entry.Begin, mainHandlers, entry.Done), entry.Timeout, entry.TimeoutStatus)
 
-	for p := range req.Begin {
-		handlers[j] = req.Begin[p]
-		j++
+	method := parseMethod(entry.Method)
+	var routes []*route
+	for _, path := range paths {
+		if method != "" {
+			routes = append(routes, newRoute(method, entry.subdomain, entry.host, path, handlers))
+			if entry.Head {
+				routes = append(routes, newRoute(MethodHead, entry.subdomain, entry.host, path, handlers))
+			}
+		} else {
+			// register to all http methods
+			for _, m := range MethodsAll {
+				routes = append(routes, newRoute(m, entry.subdomain, entry.host, path, handlers))
+			}
+		}
 	}
 
-	for p := range entry.Begin {
-		handlers[j] = entry.Begin[p]
-		j++
+	mountedQ, _ := entry.Mount.(*Q)
+	for _, r := range routes {
+		r.errors = entry.Errors
+		r.timeout = entry.Timeout
+		r.timeoutStatus = entry.TimeoutStatus
+		r.begin = entry.Begin
+		r.done = entry.Done
+		if mountedQ != nil {
+			r.mount = mountedQ
+			r.mountPrefix = mountPrefix
+		}
 	}
-	if entry.Handler != nil {
-		handlers[j] = entry.Handler
-		j++
+
+	if entry.Name != "" {
+		// make it available for the Q.Path/URL, context.RedirectTo and the {{ url }}, {{ urlpath }}
+		for _, r := range routes {
+			r.setName(entry.Name)
+		}
 	}
+	return routes
+}
+
+// mountParamName is the name of the match everything parameter of a Mount entry's route
+const mountParamName = "mountpath"
 
-	for p := range entry.Done {
-		handlers[j] = entry.Done[p]
-		j++
+// mountHandler returns the handler which serves the requests by the mount, without the matched prefix of the path
+func mountHandler(mount http.Handler) Handler {
+	return func(ctx *Context) {
+		r := new(http.Request)
+		*r = *ctx.Request
+		u := *r.URL
+		rest := mountEscapedRest(&u, ctx.Param(mountParamName), !ctx.q.Request.DisablePathEscape)
+		if rest == "" {
+			rest = slash
+		}
+		// the Path is derived by the escaped path, so an escaped slash, '%2F', is kept as it is inside a segment
+		if restURL, err := url.ParseRequestURI(rest); err == nil {
+			u.Path = restURL.Path
+			u.RawPath = restURL.RawPath
+		} else {
+			u.Path = ctx.Param(mountParamName)
+			u.RawPath = ""
+		}
+		if u.Path == "" {
+			u.Path = slash
+		}
+		r.URL = &u
+		mount.ServeHTTP(ctx.ResponseWriter, r)
 	}
+}
 
-	for p := range req.Done {
-		handlers[j] = req.Done[p]
-		j++
+// mountEscapedRest returns the escaped path of the url without the prefix matched by the mount's route,
+// the rest is the value of the match everything parameter, it's unescaped if the route matched the unescaped path
+func mountEscapedRest(u *url.URL, rest string, unescaped bool) string {
+	if !unescaped {
+		// the route matched the escaped path, the rest is escaped already
+		return rest
 	}
-	method := parseMethod(entry.Method)
-	path := entry.Path
-	if len(path) == 0 {
-		path = "/"
+	if strings.HasSuffix(u.Path, rest) {
+		prefix := u.Path[0 : len(u.Path)-len(rest)]
+		escaped := u.EscapedPath()
+		// the prefix can be escaped by the client, i.e '/a%2Fb' matches the '/a/b', find where it ends at the escaped path
+		for i := 1; i <= len(escaped); i++ {
+			if i < len(escaped) && escaped[i] != slashByte {
+				continue
+			}
+			if prefixURL, err := url.ParseRequestURI(escaped[0:i]); err == nil && prefixURL.Path == prefix {
+				return escaped[i:]
+			}
+		}
 	}
-	if method != "" {
-		req.mux.register(method, entry.subdomain, path, handlers)
-		if entry.Head {
-			req.mux.register(MethodHead, entry.subdomain, path, handlers)
+	return (&url.URL{Path: rest}).EscapedPath()
+}
+
+// buildMounts builds the mounted Q instances of the routes, if not builded before
+func (req *Request) buildMounts(routes []*route) error {
+	var errs BuildErrors
+	// the mounts which failed to build, don't try again for the rest routes of the same mount,
+	// their built stays nil, the Build sets it only if the build succeed
+	failed := make(map[*Q]bool)
+	for _, r := range routes {
+		if r.mount != nil && r.mount.built == nil && !failed[r.mount] {
+			if err := r.mount.Build(); err != nil {
+				errs = append(errs, err)
+				failed[r.mount] = true
+			}
 		}
-	} else {
-		// register to all http methods
-		for _, m := range MethodsAll {
-			req.mux.register(m, entry.subdomain, path, handlers)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// withTimeout returns the handlers with the timeoutHandler in front of them, if the timeout is positive
+func withTimeout(handlers Handlers, timeout time.Duration, statusCode int) Handlers {
+	if timeout <= 0 {
+		return handlers
+	}
+	if statusCode == 0 {
+		statusCode = StatusServiceUnavailable
+	}
+	return append(Handlers{timeoutHandler(timeout, statusCode)}, handlers...)
+}
+
+// timeoutHandler runs the next handlers with a deadline, if they don't finish in time then their response is discarded
+// and the statusCode's error handler is emitted.
+// The handlers run, by a goroutine, with a copy of the context which has its own ResponseWriter, after the timeout the copy's writes are refused,
+// the Done handlers of the entry run with the copy too, after the timeout's response has been sent, so they can't change it
+func timeoutHandler(timeout time.Duration, statusCode int) Handler {
+	return func(ctx *Context) {
+		c, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{header: make(http.Header), closeNotify: ctx.ResponseWriter.CloseNotify()}
+		// the handlers may continue after the timeout, so they run with a copy of the context which is not returned to the pool
+		chain := new(Context)
+		*chain = *ctx
+		chain.Request = ctx.Request.WithContext(c)
+		// its own writer, the body of the ctx's writer is pooled with the ctx and it's reused by the next requests
+		chain.writer = responseWriter{}
+		chain.writer.reset(tw)
+		chain.ResponseWriter = &chain.writer
+		chain.values = append(requestValues(nil), ctx.values...)
+		chain.Params = append(PathParameters(nil), ctx.Params...)
+		chain.tempFiles = append([]string(nil), ctx.tempFiles...)
+		chain.compressor = nil
+		chain.sse = nil
+
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if err := recover(); err != nil {
+					panicked <- err
+				}
+			}()
+			chain.pos++
+			if chain.pos < len(chain.handlers) {
+				chain.Serve()
+			}
+			close(done)
+		}()
+
+		select {
+		case err := <-panicked:
+			panic(err)
+		case <-done:
+			if chain.compressor != nil {
+				chain.compressor.close()
+			}
+			chain.compressor = ctx.compressor
+			chain.writer.flushBuffer()
+			tw.writeTo(ctx.ResponseWriter)
+			chain.writer = ctx.writer
+			chain.ResponseWriter = ctx.ResponseWriter
+			chain.Request = ctx.Request
+			*ctx = *chain
+		case <-c.Done():
+			tw.mu.Lock()
+			tw.timedOut = true
+			tw.mu.Unlock()
+			ctx.Request = chain.Request
+			ctx.SetStatusCode(statusCode)
+			ctx.EmitError(statusCode)
 		}
 	}
 }
 
+// timeoutWriter buffers the response of the timeoutHandler's handlers, after the timeout it refuses any write
+type timeoutWriter struct {
+	mu         sync.Mutex
+	header     http.Header
+	body       bytes.Buffer
+	statusCode int
+	timedOut   bool
+	// closeNotify is the channel of the real response writer, the handlers can run after the real context is released
+	closeNotify <-chan bool
+}
+
+// CloseNotify returns the real response writer's close notification channel, so the handlers are notified when the client goes away
+func (tw *timeoutWriter) CloseNotify() <-chan bool {
+	return tw.closeNotify
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.statusCode == 0 {
+		tw.statusCode = StatusOK
+	}
+	return tw.body.Write(b)
+}
+
+func (tw *timeoutWriter) WriteHeader(statusCode int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.statusCode != 0 {
+		return
+	}
+	tw.statusCode = statusCode
+}
+
+// writeTo writes the buffered response to the real response writer
+func (tw *timeoutWriter) writeTo(w http.ResponseWriter) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	dst := w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	if tw.statusCode != 0 {
+		w.WriteHeader(tw.statusCode)
+	}
+	w.Write(tw.body.Bytes())
+}
+
+// wrapHandlers returns the full handlers chain of a route,
+// Request.Begin, the entry's Begin, the main handlers, the entry's Done and the Request.Done
+func (req *Request) wrapHandlers(begin Handlers, main Handlers, done Handlers) Handlers {
+	handlersLen := len(req.Begin) + len(begin) + len(main) + len(done) + len(req.Done)
+	handlers := make(Handlers, 0, handlersLen)
+	handlers = append(handlers, req.Begin...)
+	handlers = append(handlers, begin...)
+	handlers = append(handlers, main...)
+	handlers = append(handlers, done...)
+	return append(handlers, req.Done...)
+}
+
+// EntryError is the error of an entry which couldn't be registered, i.e conflicts with another entry,
+// Name is empty if the entry has not a Name, Path contains the subdomain or the host
+type EntryError struct {
+	Name   string
+	Method string
+	Path   string
+	Err    error
+}
+
+// Error returns the message of the error with the entry's name, method and path
+func (e EntryError) Error() string {
+	name := e.Name
+	if name == "" {
+		name = "<unnamed>"
+	}
+	return fmt.Sprintf("Entry: '%s' %s %s: %s", name, e.Method, e.Path, strings.TrimSpace(e.Err.Error()))
+}
+
+var (
+	errEntryNoHandlers = errors.New("Entry has not a Handler, Begin or Done!")
+	errMuxUnavailable  = errors.New("Request: the router is not available, the Request is not builded yet or a custom Request.Handler is used!")
+	errEntryNotFound   = errors.New("Request: entry with name: '%s' couldn't be found!")
+)
+
+// AddEntry registers an entry, and its children entries, to a running Q instance,
+// the entry is ready to serve requests when AddEntry returns without error.
+// The Request.Begin and Done handlers are passed to the entry like any other.
+//
+// It's safe to call it while the server is serving requests,
+// returns an error if the entry conflicts with the registered routes, in this case the routes are not changed at all.
+func (req *Request) AddEntry(e Entry) error {
+	if req.mux == nil {
+		return errMuxUnavailable.Return()
+	}
+	routes := req.compileEntry(e)
+	if err := req.buildMounts(routes); err != nil {
+		return err
+	}
+	return req.mux.update(func(lookups []*route) ([]*route, error) {
+		return append(lookups, routes...), nil
+	})
+}
+
+// RemoveEntry unregisters the routes of an entry by the entry's Name (or path+subdomain+host if Name is empty) from a running Q instance,
+// the requests to the entry's path are not served when RemoveEntry returns without error.
+//
+// It's safe to call it while the server is serving requests,
+// returns an error if no entry with this name found.
+func (req *Request) RemoveEntry(entryName string) error {
+	if req.mux == nil {
+		return errMuxUnavailable.Return()
+	}
+	return req.mux.update(func(lookups []*route) ([]*route, error) {
+		routes := lookups[0:0]
+		for _, r := range lookups {
+			if r.name != entryName {
+				routes = append(routes, r)
+			}
+		}
+		if len(routes) == len(lookups) {
+			return nil, errEntryNotFound.Format(entryName)
+		}
+		return routes, nil
+	})
+}
+
+// ReplaceHandlers replaces the Handler of an entry, found by its Name (or path+subdomain+host if Name is empty), on a running Q instance,
+// the new handlers are wrapped by the entry's Begin and Done and the Request.Begin and Done handlers, like on the registration.
+//
+// It's safe to call it while the server is serving requests,
+// returns an error if no entry with this name found.
+func (req *Request) ReplaceHandlers(entryName string, handlers ...Handler) error {
+	if req.mux == nil {
+		return errMuxUnavailable.Return()
+	}
+	return req.mux.update(func(lookups []*route) ([]*route, error) {
+		found := false
+		for i, r := range lookups {
+			if r.name == entryName {
+				// don't touch the old route, it may be in use by a reader of the GetEntries
+				newRoute := *r
+				newRoute.handlers = withTimeout(req.wrapHandlers(r.begin, handlers, r.done), r.timeout, r.timeoutStatus)
+				lookups[i] = &newRoute
+				found = true
+			}
+		}
+		if !found {
+			return nil, errEntryNotFound.Format(entryName)
+		}
+		return lookups, nil
+	})
+}
+
+// buildOptionsHandlers returns the handlers of the automatic OPTIONS responder, the 'Allow' header is setted by the mux
+func (req *Request) buildOptionsHandlers() Handlers {
+	handlers := make(Handlers, 0, len(req.Begin)+len(req.Done)+1)
+	handlers = append(handlers, req.Begin...)
+	handlers = append(handlers, func(ctx *Context) {
+		ctx.SetHeader(contentLength, "0")
+		ctx.SetStatusCode(StatusOK)
+	})
+	return append(handlers, req.Done...)
+}
+
 // CompiledEntry is the parsed entry, contains the full path (if children of party or subdomain's)
 // available,only, after the server has been fully builded and is running
 type CompiledEntry interface {
@@ -220,8 +687,18 @@ type CompiledEntry interface {
 	Handlers() Handlers
 }
 
+// HostEntry is implemented by the CompiledEntries, Host returns the full host of the entry, i.e 'example.org' or '*.example.org:8080', empty if it's served by the Q.Host,
+// it's not part of the CompiledEntry in order to not break its implementations, type assert the CompiledEntry to get it
+type HostEntry interface {
+	CompiledEntry
+	Host() string
+}
+
 // GetEntry returns the (registered) CompiledEntry found by its Name
 func (req *Request) GetEntry(entryName string) CompiledEntry {
+	if req.mux == nil {
+		return nil
+	}
 	if r := req.mux.lookup(entryName); r != nil {
 		return r
 	}
@@ -230,8 +707,12 @@ func (req *Request) GetEntry(entryName string) CompiledEntry {
 
 // GetEntries returns all (registered) CompiledEntries
 func (req *Request) GetEntries() (entries []CompiledEntry) {
-	for i := range req.mux.lookups {
-		entries = append(entries, req.mux.lookups[i])
+	if req.mux == nil {
+		return
+	}
+	routes := req.mux.routes()
+	for i := range routes {
+		entries = append(entries, routes[i])
 	}
 	return
 }
@@ -286,6 +767,83 @@ func (s Profile) ParseEntry(e Entry) Entry {
 	return e
 }
 
+// EntryInfo describes a registered route, the method, host, subdomain, path, name and the handlers' function names
+// used by the Routes entry parser and the 'routes' SSH command
+type EntryInfo struct {
+	Method    string   `json:"method"`
+	Host      string   `json:"host"`
+	Subdomain string   `json:"subdomain"`
+	Path      string   `json:"path"`
+	Name      string   `json:"name"`
+	Handlers  []string `json:"handlers"`
+}
+
+// GetEntriesInfo returns the description of all (registered) CompiledEntries, with the same order as the GetEntries
+func (req *Request) GetEntriesInfo() []EntryInfo {
+	entries := req.GetEntries()
+	infos := make([]EntryInfo, len(entries))
+	for i, e := range entries {
+		handlers := e.Handlers()
+		handlerNames := make([]string, len(handlers))
+		for j := range handlers {
+			handlerNames[j] = HandlerName(handlers[j])
+		}
+		var host string
+		if h, ok := e.(HostEntry); ok {
+			host = h.Host()
+		}
+		infos[i] = EntryInfo{Method: e.Method(), Host: host, Subdomain: e.Subdomain(), Path: e.Path(), Name: e.Name(), Handlers: handlerNames}
+	}
+	return infos
+}
+
+// HandlerName returns the full name of the handler's function, i.e: 'github.com/kataras/q.Profile.ParseEntry.func1'
+func HandlerName(h Handler) string {
+	if h == nil {
+		return ""
+	}
+	if fn := runtime.FuncForPC(reflect.ValueOf(h).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return ""
+}
+
+// Routes is the entry which serves the registered routes, as JSON if the client accepts 'application/json' or the ?format=json, otherwise as html table.
+// The routes changed at runtime are served also.
+type Routes struct{}
+
+// ParseEntry returns the routes entry, implements the EntryParser
+func (s Routes) ParseEntry(e Entry) Entry {
+	if e.Path == "" {
+		e.Path = "/routes"
+	}
+	if e.Method == "" {
+		e.Method = MethodGet
+	}
+
+	e.Handler = func(ctx *Context) {
+		infos := ctx.q.Request.GetEntriesInfo()
+		if ctx.URLParam("format") == "json" || strings.Contains(ctx.RequestHeader(acceptHeader), contentJSON) {
+			ctx.JSON(infos)
+			return
+		}
+
+		buf := &bytes.Buffer{}
+		buf.WriteString("<table>\n<tr><th>Method</th><th>Host</th><th>Subdomain</th><th>Path</th><th>Name</th><th>Handlers</th></tr>\n")
+		for _, info := range infos {
+			handlerNames := make([]string, len(info.Handlers))
+			for i := range info.Handlers {
+				handlerNames[i] = html.EscapeString(info.Handlers[i])
+			}
+			buf.WriteString("<tr><td>" + info.Method + "</td><td>" + html.EscapeString(info.Host) + "</td><td>" + html.EscapeString(info.Subdomain) + "</td><td>" + html.EscapeString(info.Path) +
+				"</td><td>" + html.EscapeString(info.Name) + "</td><td>" + strings.Join(handlerNames, "<br/>") + "</td></tr>\n")
+		}
+		buf.WriteString("</table>")
+		ctx.HTML(buf.String())
+	}
+	return e
+}
+
 // NewLoggerHandler is a basic Logger middleware/Handler (not an Entry Parser)
 func NewLoggerHandler(writer io.Writer, calculateLatency ...bool) Handler {
 	shouldNext := false
@@ -308,7 +866,7 @@ func NewLoggerHandler(writer io.Writer, calculateLatency ...bool) Handler {
 		latency = endTime.Sub(startTime)
 		date = endTime.Format("01/02 - 15:04:05")
 
-		status = strconv.Itoa(ctx.StatusCode()) // if ctx.SetStatusCode doesn't call itself then this will be always 200, default error handlers uses that so it should be ok
+		status = strconv.Itoa(ctx.StatusCode())
 		ip = ctx.RemoteAddr()
 
 		//finally print the logs to the ssh
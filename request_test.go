@@ -0,0 +1,155 @@
+package q
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUpdateErrors(t *testing.T) {
+	qq := newQ(Entries{Entry{Method: "GET", Path: "/", Handler: echoParams}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				serve(qq, "GET", "/missing")
+			}
+		}()
+	}
+	qq.Request.updateErrors(func(errs Errors) Errors {
+		errs[404] = func(ctx *Context) {
+			ctx.SetStatusCode(404)
+			io.WriteString(ctx.ResponseWriter, "updated")
+		}
+		return errs
+	})
+	wg.Wait()
+
+	if rec := serve(qq, "GET", "/missing"); rec.Code != 404 || rec.Body.String() != "updated" {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+	if _, ok := qq.Request.Errors[404]; !ok {
+		t.Fatalf("the default handler should be kept in the Request.Errors")
+	}
+}
+
+func TestRuntimeEntries(t *testing.T) {
+	qq := newQ(Entries{{Method: "GET", Path: "/a", Name: "a", Handler: func(ctx *Context) { io.WriteString(ctx.ResponseWriter, "a") }}})
+	if rec := serve(qq, "GET", "/b"); rec.Code != 404 {
+		t.Fatal(rec.Code)
+	}
+	if err := qq.Request.AddEntry(Entry{Method: "GET", Path: "/b/:id", Name: "b", Handler: echoParams}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(qq, "GET", "/b/5"); rec.Code != 200 || rec.Body.String() != "id=5" {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	if err := qq.Request.AddEntry(Entry{Method: "GET", Path: "/b/:other", Handler: echoParams}); err == nil {
+		t.Fatal("expected conflict")
+	}
+	if rec := serve(qq, "GET", "/b/5"); rec.Code != 200 {
+		t.Fatal("conflict changed routes", rec.Code)
+	}
+	if err := qq.Request.ReplaceHandlers("a", func(ctx *Context) { io.WriteString(ctx.ResponseWriter, "A") }); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(qq, "GET", "/a"); rec.Body.String() != "A" {
+		t.Fatal(rec.Body.String())
+	}
+	if err := qq.Request.RemoveEntry("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := qq.Request.RemoveEntry("b"); err == nil {
+		t.Fatal("expected not found")
+	}
+	if rec := serve(qq, "GET", "/b/5"); rec.Code != 404 {
+		t.Fatal(rec.Code)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() { defer wg.Done(); serve(qq, "GET", "/a") }()
+		go func() { defer wg.Done(); qq.Request.ReplaceHandlers("a", echoParams) }()
+	}
+	wg.Wait()
+}
+
+func TestReplaceHandlers(t *testing.T) {
+	qq := (&Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{
+		Begin: Handlers{func(ctx *Context) { ctx.SetHeader("X-Request", "1") }},
+		Entries: Entries{{Path: "/p", Begin: Handlers{func(ctx *Context) { ctx.SetHeader("X-Parent", "1") }}, Entries: Entries{
+			{Method: "GET", Path: "/a", Name: "a", Handler: writeS("a"),
+				Begin: Handlers{func(ctx *Context) { ctx.SetHeader("X-Begin", "1") }},
+				Done:  Handlers{func(ctx *Context) { io.WriteString(ctx.ResponseWriter, "|done") }}},
+		}}},
+	}}).Go()
+	if err := qq.Request.ReplaceHandlers("a", writeS("A")); err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(qq, "GET", "/p/a")
+	if rec.Body.String() != "A|done" {
+		t.Errorf("got %q", rec.Body.String())
+	}
+	for _, h := range []string{"X-Request", "X-Parent", "X-Begin"} {
+		if rec.Header().Get(h) != "1" {
+			t.Errorf("the %s is not kept: %v", h, rec.Header())
+		}
+	}
+}
+
+func namedHandler(ctx *Context) {}
+
+func TestRoutesEntry(t *testing.T) {
+	qq := newQ(Entries{{Method: "GET", Path: "/a/:id", Name: "a", Handler: namedHandler}, {Parser: Routes{}}})
+	rec := serve(qq, "GET", "/routes?format=json")
+	if !strings.Contains(rec.Body.String(), `"handlers":["github.com/kataras/q.namedHandler"]`) {
+		t.Fatal(rec.Body.String())
+	}
+	rec = serve(qq, "GET", "/routes")
+	if !strings.Contains(rec.Body.String(), "<td>/a/:id</td>") {
+		t.Fatal(rec.Body.String())
+	}
+}
+
+func TestUse(t *testing.T) {
+	var logged []string
+	qq := (&Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{
+		Use: Handlers{
+			func(ctx *Context) { ctx.SetHeader("X-Request-Id", "1") },
+			func(ctx *Context) {
+				ctx.Next()
+				name := "<nil>"
+				if e := ctx.Entry(); e != nil {
+					name = e.Name()
+				}
+				logged = append(logged, name+":"+strconv.Itoa(ctx.StatusCode()))
+			},
+		},
+		Entries: Entries{{Method: "GET", Path: "/a", Name: "a", Handler: func(ctx *Context) { ctx.Next(); ctx.WriteString("a") }}},
+	}}).Go()
+	for _, c := range []struct {
+		m, p string
+		code int
+	}{{"GET", "/a", 200}, {"GET", "/b", 404}, {"POST", "/a", 405}} {
+		rec := serve(qq, c.m, c.p)
+		if rec.Code != c.code || rec.Header().Get("X-Request-Id") != "1" {
+			t.Error(c, rec.Code, rec.Header())
+		}
+	}
+	if len(logged) != 3 || logged[0] != "a:200" || logged[1] != "<nil>:404" || logged[2] != "<nil>:405" {
+		t.Error(logged)
+	}
+
+	stop := (&Q{Host: "mydomain.com:80", DisableServer: true, Request: Request{
+		Use:     Handlers{func(ctx *Context) { ctx.EmitError(403) }},
+		Entries: Entries{{Method: "GET", Path: "/a", Handler: func(ctx *Context) { ctx.WriteString("a") }}},
+	}}).Go()
+	if rec := serve(stop, "GET", "/a"); rec.Code != 403 {
+		t.Error(rec.Code, rec.Body.String())
+	}
+}
@@ -1,6 +1,7 @@
 package q
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/kataras/q/errors"
@@ -82,7 +83,10 @@ func (r ResponseEngineFunc) Response(obj interface{}, options ...map[string]inte
 	return r(obj, options...)
 }
 
-var errNoResponseEngineFound = errors.New("No response engine found")
+var (
+	errNoResponseEngineFound = errors.New("No response engine found")
+	errNotAcceptable         = errors.New("No response engine found for the Accept: '%s'")
+)
 
 // on context: Send(contentType string, obj interface{}, ...options)
 
@@ -90,7 +94,7 @@ func (r *responseEngineMap) add(engine ResponseEngine) {
 	r.values = append(r.values, engine)
 }
 
-// the gzip and charset options are built'n with Q
+// the gzip, the compression by the negotiated encoder, and charset options are built'n with Q
 func (r *responseEngineMap) render(ctx *Context, obj interface{}, options ...map[string]interface{}) error {
 
 	if r == nil {
@@ -123,15 +127,16 @@ func (r *responseEngineMap) render(ctx *Context, obj interface{}, options ...map
 	}
 	ctx.SetContentType(ctype)
 
-	if gzipEnabled && ctx.clientAllowsGzip() {
-		if _, err := ctx.WriteGzip(finalResult); err != nil {
-			return err
+	if gzipEnabled {
+		if encoder, w := ctx.acquireEncoder(ctx.ResponseWriter, ctype, int64(len(finalResult))); encoder != nil {
+			if _, err := w.Write(finalResult); err != nil {
+				encoder.Release(w)
+				return err
+			}
+			return encoder.Release(w)
 		}
-		ctx.ResponseWriter.Header().Add(varyHeader, acceptEncodingHeader)
-		ctx.SetHeader(contentEncodingHeader, "gzip")
-	} else {
-		ctx.Write(finalResult)
 	}
+	ctx.Write(finalResult)
 
 	return nil
 }
@@ -208,6 +213,133 @@ func (r *responseEngines) add(engine ResponseEngine, forContentTypesOrKeys ...st
 
 }
 
+// negotiationOrder is the preference of the default response engines when the client accepts more than one with the same quality, i.e */*,
+// they are preferred over the user-defined response engines
+var negotiationOrder = [...]string{contentJSON, contentXML, contentJSONP, contentMarkdown, contentText, contentBinary}
+
+// canNegotiate returns false if the default response engine of the key can't render the obj,
+// the markdown and the text engines render only strings and the binary only []byte
+func canNegotiate(key string, obj interface{}) bool {
+	switch key {
+	case contentMarkdown, contentText:
+		_, ok := obj.(string)
+		return ok
+	case contentBinary:
+		_, ok := obj.([]byte)
+		return ok
+	}
+	return true
+}
+
+// acceptRange is a media range of the Accept header with its quality, i.e 'text/*;q=0.8'
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+// parseAccept returns the media ranges of an Accept header, an empty header accepts everything
+func parseAccept(accept string) []acceptRange {
+	if strings.TrimSpace(accept) == "" {
+		return []acceptRange{{mediaType: "*/*", quality: 1}}
+	}
+	parts := strings.Split(accept, ",")
+	ranges := make([]acceptRange, 0, len(parts))
+	for _, part := range parts {
+		params := strings.Split(part, ";")
+		ar := acceptRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), quality: 1}
+		if ar.mediaType == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) > 2 && (param[0] == 'q' || param[0] == 'Q') && param[1] == '=' {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q >= 0 && q <= 1 {
+					ar.quality = q
+				}
+			}
+		}
+		ranges = append(ranges, ar)
+	}
+	return ranges
+}
+
+// acceptQuality returns the quality of the most specific media range which matches with the media type
+// and how specific is the range, 3 for 'type/subtype', 2 for 'type/*', 1 for '*/*', 0 if none matches
+func acceptQuality(ranges []acceptRange, mediaType string) (quality float64, specificity int) {
+	mainType := mediaType
+	if idx := strings.IndexByte(mediaType, slashByte); idx != -1 {
+		mainType = mediaType[0:idx]
+	}
+	for _, ar := range ranges {
+		s := 0
+		if ar.mediaType == mediaType {
+			s = 3
+		} else if ar.mediaType == mainType+"/*" {
+			s = 2
+		} else if ar.mediaType == "*/*" {
+			s = 1
+		}
+		if s > specificity {
+			quality, specificity = ar.quality, s
+		}
+	}
+	return
+}
+
+// negotiate returns the response engine, which can render the obj, that matches best with the Accept header, by the quality and then by how specific is the media range,
+// nil if the client doesn't accept any of them
+func (r *responseEngines) negotiate(accept string, obj interface{}) *responseEngineMap {
+	ranges := parseAccept(accept)
+	var (
+		best            *responseEngineMap
+		bestQuality     float64
+		bestSpecificity int
+	)
+	for _, engineMap := range r.negotiationCandidates() {
+		if !canNegotiate(engineMap.key, obj) {
+			continue
+		}
+		quality, specificity := acceptQuality(ranges, engineMap.contentType)
+		if engineMap.key != engineMap.contentType && strings.IndexByte(engineMap.key, slashByte) != -1 {
+			// i.e the text/markdown which is rendered as text/html
+			if q, s := acceptQuality(ranges, engineMap.key); s > specificity {
+				quality, specificity = q, s
+			}
+		}
+		if specificity == 0 || quality == 0 {
+			continue
+		}
+		if quality > bestQuality || (quality == bestQuality && specificity > bestSpecificity) {
+			best, bestQuality, bestSpecificity = engineMap, quality, specificity
+		}
+	}
+	return best
+}
+
+// negotiationCandidates returns the response engines in the order of preference, the defaults by the negotiationOrder first and then the user-defined
+func (r *responseEngines) negotiationCandidates() []*responseEngineMap {
+	candidates := make([]*responseEngineMap, 0, len(r.engines))
+	isDefault := func(key string) bool {
+		for _, k := range negotiationOrder {
+			if k == key {
+				return true
+			}
+		}
+		return false
+	}
+	for _, key := range negotiationOrder {
+		if engineMap := r.getBy(key); engineMap != nil {
+			candidates = append(candidates, engineMap)
+		}
+	}
+	for _, engineMap := range r.engines {
+		if !isDefault(engineMap.key) {
+			candidates = append(candidates, engineMap)
+		}
+	}
+	return candidates
+}
+
 func (r *responseEngines) getBy(key string) *responseEngineMap {
 	for i, n := 0, len(r.engines); i < n; i++ {
 		if r.engines[i].key == key {
@@ -0,0 +1,140 @@
+package q
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"github.com/kataras/q/errors"
+)
+
+// MinSessionKeyLen is the minimum length of a Session.Keys' key, in bytes
+const MinSessionKeyLen = 16
+
+var (
+	errSessionKeyShort   = errors.New("The session key at index %d is %d bytes long, it should be at least %d bytes")
+	errCookieInvalid     = errors.New("The value of the cookie: '%s' is invalid or it has been tampered")
+	errCookieEncryption  = errors.New("Unable to encrypt the cookie: '%s'. Trace: %s")
+	cookieSignatureLabel = []byte("q-cookie-signature")
+	cookieEncryptLabel   = []byte("q-cookie-encryption")
+)
+
+// cookieCodec signs, or encrypts, the values of the session and the flash cookies by the Session.Keys,
+// the first key signs, or encrypts, the values and all keys verify, or decrypt, them, so the keys can be rotated
+type cookieCodec struct {
+	signKeys [][]byte
+	aeads    []cipher.AEAD
+}
+
+// newCookieCodec returns the cookieCodec of the keys, nil if there are no keys, the values are encrypted by the AES-GCM if encrypt is true,
+// each key is used to derive a signing key and an AES-256 key, by the HMAC-SHA256, so the keys can have any length above the MinSessionKeyLen
+func newCookieCodec(keys [][]byte, encrypt bool) (*cookieCodec, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	c := &cookieCodec{}
+	for i, key := range keys {
+		if len(key) < MinSessionKeyLen {
+			return nil, errSessionKeyShort.Format(i, len(key), MinSessionKeyLen)
+		}
+		if !encrypt {
+			c.signKeys = append(c.signKeys, deriveCookieKey(key, cookieSignatureLabel))
+			continue
+		}
+		block, err := aes.NewCipher(deriveCookieKey(key, cookieEncryptLabel))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+// deriveCookieKey returns a 32 bytes key, for the label's usage, of the key
+func deriveCookieKey(key []byte, label []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(label)
+	return mac.Sum(nil)
+}
+
+// signature returns the HMAC-SHA256 of the cookie's name and payload, the name is signed too so a value can't be moved to another cookie
+func signature(key []byte, name string, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{'|'})
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// encode returns the signed, 'base64(value).base64(signature)', or the encrypted, 'base64(nonce+ciphertext)', value of the cookie,
+// the value as it is if the codec is nil
+func (c *cookieCodec) encode(name string, value string) (string, error) {
+	if c == nil {
+		return value, nil
+	}
+	if len(c.aeads) > 0 {
+		aead := c.aeads[0]
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return "", errCookieEncryption.Format(name, err.Error())
+		}
+		// the name is the additional data, so a value can't be moved to another cookie
+		sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+		return base64.RawURLEncoding.EncodeToString(sealed), nil
+	}
+	payload := base64.RawURLEncoding.EncodeToString([]byte(value))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature(c.signKeys[0], name, payload)), nil
+}
+
+// decode returns the value of an encoded cookie's value, it's verified, or decrypted, by each of the keys,
+// returns an error if none of the keys matches, the value is invalid or it has been tampered
+func (c *cookieCodec) decode(name string, value string) (string, error) {
+	if c == nil {
+		return value, nil
+	}
+	if len(c.aeads) > 0 {
+		sealed, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return "", errCookieInvalid.Format(name)
+		}
+		for _, aead := range c.aeads {
+			nonceSize := aead.NonceSize()
+			if len(sealed) < nonceSize {
+				break
+			}
+			if plain, err := aead.Open(nil, sealed[0:nonceSize], sealed[nonceSize:], []byte(name)); err == nil {
+				return string(plain), nil
+			}
+		}
+		return "", errCookieInvalid.Format(name)
+	}
+
+	dotIdx := strings.LastIndexByte(value, '.')
+	if dotIdx == -1 {
+		return "", errCookieInvalid.Format(name)
+	}
+	payload := value[0:dotIdx]
+	sig, err := base64.RawURLEncoding.DecodeString(value[dotIdx+1:])
+	if err != nil {
+		return "", errCookieInvalid.Format(name)
+	}
+	for _, key := range c.signKeys {
+		if hmac.Equal(sig, signature(key, name, payload)) {
+			plain, err := base64.RawURLEncoding.DecodeString(payload)
+			if err != nil {
+				break
+			}
+			return string(plain), nil
+		}
+	}
+	return "", errCookieInvalid.Format(name)
+}
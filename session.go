@@ -1,11 +1,20 @@
 package q
 
 import (
-	"container/list"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/kataras/q/errors"
 )
 
 // -------------------------------------------------------------------------------------
@@ -35,9 +44,19 @@ type (
 		// Default infinitive/unlimited life duration(0)
 
 		Expires time.Duration
-		// GcDuration every how much duration(GcDuration) the memory should be clear for unused cookies (GcDuration)
-		// for example: time.Duration(2)*time.Hour. it will check every 2 hours if cookie hasn't be used for 2 hours,
-		// deletes it from backend memory until the user comes back, then the session continue to work as it was
+		// IdleTimeout the duration of which an unused session expires, each request which reads the session extends its lifetime,
+		// it's enforced when the session is read, not only by the GcDuration's sweep.
+		//
+		// Defaults to the Expires, or to the GcDuration if the Expires is 0 or -1
+		IdleTimeout time.Duration
+		// MaxLifetime the duration of which a session expires since its creation, used or not, the ctx.SessionRegenerate doesn't extend it,
+		// it's enforced when the session is read, not only by the GcDuration's sweep.
+		//
+		// Default infinitive/unlimited life duration(0)
+		MaxLifetime time.Duration
+		// GcDuration every how much duration(GcDuration) the Backend should remove the expired sessions,
+		// it's also the idle lifetime of the sessions when the IdleTimeout and the Expires are 0 or -1, for example: time.Duration(2)*time.Hour.
+		// it will check every 2 hours if a session hasn't be used for 2 hours and deletes it from the Backend
 		//
 		// Default 2 hours
 		GcDuration time.Duration
@@ -45,13 +64,65 @@ type (
 		// DisableSubdomainPersistence set it to true in order dissallow your q subdomains to have access to the session cookie
 		// defaults to false
 		DisableSubdomainPersistence bool
+		// Domain the domain of the session and the flash cookies, i.e "mydomain.com".
+		// Defaults to the registrable domain of the request's host, by the PublicSuffixList, i.e the "mydomain.co.uk" of the "www.mydomain.co.uk",
+		// so the subdomains share the cookies, unless the DisableSubdomainPersistence is true
+		Domain string
+		// PublicSuffixList is the public suffix list which the default Domain is derived by, i.e the publicsuffix.List of the golang.org/x/net/publicsuffix,
+		// it's opt-in so q doesn't depend on it.
+		//
+		// Defaults to nil, the cookies are host-only, unless the Domain is set
+		PublicSuffixList cookiejar.PublicSuffixList
+		// Path the path of the session and the flash cookies
+		// Defaults to "/"
+		Path string
+		// Secure set it to true in order to send the session and the flash cookies only over https
+		// defaults to false, it's always true if the SameSite is SameSiteNone
+		Secure bool
+		// SameSite the SameSite attribute of the session and the flash cookies, SameSiteLax, SameSiteStrict or SameSiteNone,
+		// the SameSiteNone requires the Secure
+		// Defaults to empty, the attribute is not sent and the browser decides
+		SameSite string
+		// Keys are the secret keys which sign the values of the session and the flash cookies by the HMAC-SHA256, at least MinSessionKeyLen bytes each.
+		// The first key signs the values and all keys verify them, so a new key can be prepended and an old one can be removed later, when its cookies are expired.
+		// A session cookie which is forged or tampered is ignored, a new session starts, a flash cookie is ignored.
+		//
+		// Defaults to nil, the cookies are not signed
+		Keys [][]byte
+		// Encrypt set it to true in order to encrypt the values of the session and the flash cookies by the AES-GCM, instead of signing them, with the same Keys,
+		// it's ignored if there are no Keys
+		// defaults to false
+		Encrypt bool
+		// Backend is the store of the sessions, the only one, the replicas of an app which share a Backend, i.e the redis one, share their sessions too.
+		// Defaults to the NewMemorySessionBackend, the sessions are kept in memory, per process
+		Backend SessionBackend
 		// UseSessionDB registers a session database, you can register more than one
 		// accepts a session database which implements a Load(sid string) map[string]interface{} and an Update(sid string, newValues map[string]interface{})
-		// the only reason that a session database will be useful for you is when you want to keep the session's values/data after the app restart
+		// the session databases are mirrors of the Backend, they're updated on each change and a session is loaded from them when the Backend doesn't have it,
+		// i.e when you want to keep the session's values/data after the app restart with the memory Backend
 		// a session database doesn't have write access to the session, it doesn't accept the context, so forget 'cookie database' for sessions, I will never allow that, for your protection.
 		//
 		// Note: Don't worry if no session database is registered, your context.Session will continue to work.
 		Databases Databases
+		// Codec serializes the values of the sessions, it's passed to the Backend and the Databases which implement the SessionCodecSetter, i.e the redis one,
+		// look the GobSessionCodec, the JSONSessionCodec and the msgpack.Codec of the sessioncodec/msgpack
+		// Defaults to the GobSessionCodec
+		Codec SessionCodec
+	}
+	// SessionBackend is the store of the sessions, the Session.Backend, it's the authoritative one, look the NewMemorySessionBackend and the sessiondb/redis.
+	// The values which are passed to the Set or returned by the Get are not shared with the backend, they're copied or serialized
+	SessionBackend interface {
+		// Get returns the values of a session, nil if the session doesn't exist or it's expired
+		Get(sid string) (map[string]interface{}, error)
+		// Set stores the values of a session, the session expires after the ttl, 0 for never
+		Set(sid string, values map[string]interface{}, ttl time.Duration) error
+		// Delete removes a session
+		Delete(sid string) error
+		// Touch extends the expiration of a session by the ttl, from now, without changing its values
+		Touch(sid string, ttl time.Duration) error
+		// Expire removes the expired sessions, it's called every Session.GcDuration,
+		// the backends which expire their keys by their own can do nothing
+		Expire() error
 	}
 	// SessionDatabase is the interface which all session databases should implement
 	// By design it doesn't support any type of cookie store like other frameworks, I want to protect you, believe me, no context access (although we could)
@@ -67,7 +138,94 @@ type (
 	Databases []SessionDatabase
 )
 
-func (s Session) newManager() *sessionsManager {
+const (
+	// SameSiteLax the cookies are sent with the same-site requests and the top-level navigations from other sites, i.e by a link
+	SameSiteLax = "Lax"
+	// SameSiteStrict the cookies are sent only with the same-site requests
+	SameSiteStrict = "Strict"
+	// SameSiteNone the cookies are sent with the cross-site requests too, they're always Secure
+	SameSiteNone = "None"
+)
+
+var (
+	errSessionSameSite      = errors.New("Invalid Session.SameSite: '%s', it should be empty, SameSiteLax, SameSiteStrict or SameSiteNone")
+	errSessionValueNotFound = errors.New("Unable to decode the session value: '%s'. Trace: the key does not exist")
+	errSessionDecodePtr     = errors.New("Unable to decode the session value: '%s'. Trace: a non-nil pointer is required")
+	errSessionDecode        = errors.New("Unable to decode the session value: '%s'. Trace: %s")
+)
+
+// validate returns an error if the SameSite is invalid
+func (s Session) validate() error {
+	switch s.SameSite {
+	case "", SameSiteLax, SameSiteStrict, SameSiteNone:
+		return nil
+	}
+	return errSessionSameSite.Format(s.SameSite)
+}
+
+// cookieDomain returns the domain of the session and the flash cookies, the Domain or the registrable domain of the request's host,
+// empty if the DisableSubdomainPersistence is true, there is no PublicSuffixList or the host is an IP, a local name or a public suffix, the cookies are host-only then
+func (s Session) cookieDomain(ctx *Context) string {
+	if s.Domain != "" {
+		return s.Domain
+	}
+	if s.DisableSubdomainPersistence || s.PublicSuffixList == nil {
+		return ""
+	}
+	host := ctx.Host()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if !validCookieDomain(host) || net.ParseIP(host) != nil {
+		return ""
+	}
+	// i.e the mydomain.co.uk of the sub.mydomain.co.uk, not the co.uk
+	suffix := s.PublicSuffixList.PublicSuffix(host)
+	dotIdx := len(host) - len(suffix) - 1
+	if dotIdx <= 0 || host[dotIdx] != '.' {
+		// the host is a public suffix
+		return ""
+	}
+	return "." + host[strings.LastIndexByte(host[0:dotIdx], '.')+1:] // . to allow persistance
+}
+
+// acquireCookie returns a cookie, from the pool, with the Path, the Domain, the Secure and the HttpOnly of the session,
+// add it by the addCookie, the SameSite is not a field of the http.Cookie
+func (s Session) acquireCookie(ctx *Context, name string, value string) *http.Cookie {
+	cookie := AcquireCookie()
+	cookie.Name = name
+	cookie.Value = value
+	cookie.Path = s.Path
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	cookie.Domain = s.cookieDomain(ctx)
+	cookie.Secure = s.Secure || s.SameSite == SameSiteNone
+	cookie.HttpOnly = true
+	return cookie
+}
+
+// addCookie adds the cookie with the SameSite of the session
+func (s Session) addCookie(ctx *Context, cookie *http.Cookie) {
+	if v := cookie.String(); v != "" {
+		if s.SameSite != "" {
+			v += "; SameSite=" + s.SameSite
+		}
+		ctx.ResponseWriter.Header().Add("Set-Cookie", v)
+	}
+}
+
+// removeCookie deletes a session or a flash cookie, with the Path and the Domain which it was added
+func (s Session) removeCookie(ctx *Context, name string) {
+	cookie := s.acquireCookie(ctx, name, "")
+	cookie.Expires = CookieExpireDelete
+	cookie.MaxAge = -1
+	s.addCookie(ctx, cookie)
+	ReleaseCookie(cookie)
+}
+
+func (s Session) newManager(logger *log.Logger, events EventEmmiter) *sessionsManager {
 	if s.Cookie == "" { // means disable sessions
 		return nil
 	}
@@ -75,8 +233,18 @@ func (s Session) newManager() *sessionsManager {
 	if s.GcDuration <= 0 {
 		s.GcDuration = time.Duration(2) * time.Hour
 	}
+	if s.Backend == nil {
+		s.Backend = NewMemorySessionBackend()
+	}
+	if s.Codec == nil {
+		s.Codec = GobSessionCodec
+	}
+	configureSessionStore(s.Backend, s.Codec, logger)
+	for i := range s.Databases {
+		configureSessionStore(s.Databases[i], s.Codec, logger)
+	}
 	// init and start the sess manager
-	sess := newSessionsManager(s)
+	sess := newSessionsManager(s, logger, events)
 
 	// register all available session databases
 	for i := range s.Databases {
@@ -86,6 +254,16 @@ func (s Session) newManager() *sessionsManager {
 	return sess
 }
 
+// configureSessionStore passes the codec and the logger to a Backend or a session database, if it accepts them
+func configureSessionStore(store interface{}, codec SessionCodec, logger *log.Logger) {
+	if setter, ok := store.(SessionCodecSetter); ok {
+		setter.SetCodec(codec)
+	}
+	if setter, ok := store.(SessionLoggerSetter); ok {
+		setter.SetLogger(logger)
+	}
+}
+
 // SessionStore is  session's store interface
 // implemented by the internal sessionStore iteral, normally the end-user will never use this interface.
 type SessionStore interface {
@@ -93,6 +271,10 @@ type SessionStore interface {
 	Get(string) interface{}
 	GetString(key string) string
 	GetInt(key string) int
+	GetInt64(key string) int64
+	GetFloat64(key string) float64
+	GetBool(key string) bool
+	Decode(key string, v interface{}) error
 	GetAll() map[string]interface{}
 	VisitAll(cb func(k string, v interface{}))
 	Set(string, interface{})
@@ -106,30 +288,42 @@ type SessionStore interface {
 // -------------------------------------------------------------------------------------
 // -------------------------------------------------------------------------------------
 
-// sessionStore is an 'object' which wraps the session provider with its session databases, only frontend user has access to this session object.
+// sessionStore is an 'object' which wraps the values of a session for a request, only frontend user has access to this session object.
+// the values are loaded from the Backend at the start of the request and each change is stored to the Backend and the session databases.
 // this is really used on context and everywhere inside q
 // implements the SessionStore interface
 type sessionStore struct {
-	sid              string
-	values           map[string]interface{} // here is the real values
-	mu               sync.Mutex
-	lastAccessedTime time.Time
-	createdAt        time.Time
-	provider         *sessionProvider
+	sid      string
+	values   map[string]interface{} // here is the real values
+	created  time.Time
+	accessed time.Time
+	mu       sync.RWMutex
+	manager  *sessionsManager
 }
 
+const (
+	// sessionCreatedKey is the key of the session's creation time, in unix seconds, inside the values which are stored to the Backend and the session databases,
+	// it's removed from the values when they're loaded
+	sessionCreatedKey = "_q_session_created_"
+	// sessionAccessedKey is the key of the session's last access time, in unix milliseconds, so it's precise enough for the short IdleTimeouts,
+	// it's refreshed by the reads every tenth of the IdleTimeout, look sessionsManager.accessResolution
+	sessionAccessedKey = "_q_session_accessed_"
+)
+
 // ID returns the session's id
 func (s *sessionStore) ID() string {
-	return s.sid
+	s.mu.RLock()
+	sid := s.sid
+	s.mu.RUnlock()
+	return sid
 }
 
 // Get returns the value of an entry by its key
 func (s *sessionStore) Get(key string) interface{} {
-	s.provider.update(s.sid)
-	if value, found := s.values[key]; found {
-		return value
-	}
-	return nil
+	s.mu.RLock()
+	value := s.values[key]
+	s.mu.RUnlock()
+	return value
 }
 
 // GetString same as Get but returns as string, if nil then returns an empty string
@@ -145,16 +339,112 @@ func (s *sessionStore) GetString(key string) string {
 }
 
 // GetInt same as Get but returns as int, if nil then returns -1
+// the other numeric types, i.e the float64 of the JSONSessionCodec, are converted
 func (s *sessionStore) GetInt(key string) int {
-	if value := s.Get(key); value != nil {
-		if v, ok := value.(int); ok {
-			return v
-		}
+	if v, ok := toInt64(s.Get(key)); ok {
+		return int(v)
 	}
 
 	return -1
 }
 
+// GetInt64 same as Get but returns as int64, if nil then returns -1
+// the other numeric types, i.e the float64 of the JSONSessionCodec, are converted
+func (s *sessionStore) GetInt64(key string) int64 {
+	if v, ok := toInt64(s.Get(key)); ok {
+		return v
+	}
+
+	return -1
+}
+
+// GetFloat64 same as Get but returns as float64, if nil then returns -1
+// the other numeric types are converted
+func (s *sessionStore) GetFloat64(key string) float64 {
+	value := s.Get(key)
+	switch v := value.(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	}
+	if v, ok := toInt64(value); ok {
+		return float64(v)
+	}
+
+	return -1
+}
+
+// GetBool same as Get but returns as bool, if nil then returns false
+func (s *sessionStore) GetBool(key string) bool {
+	if v, ok := s.Get(key).(bool); ok {
+		return v
+	}
+
+	return false
+}
+
+// Decode stores the value of the key to the v, a pointer, i.e &user,
+// the values which are not of the v's type, i.e the map[string]interface{} of a struct which is decoded by the JSONSessionCodec, are converted through the json.
+// Returns an error if the key doesn't exist or the value can't be converted
+func (s *sessionStore) Decode(key string, v interface{}) error {
+	value := s.Get(key)
+	if value == nil {
+		return errSessionValueNotFound.Format(key)
+	}
+	ptr := reflect.ValueOf(v)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return errSessionDecodePtr.Format(key)
+	}
+	if rv := reflect.ValueOf(value); rv.Type().AssignableTo(ptr.Elem().Type()) {
+		ptr.Elem().Set(rv)
+		return nil
+	}
+	b, err := json.Marshal(value)
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		return errSessionDecode.Format(key, err.Error())
+	}
+	return nil
+}
+
+// toInt64 returns the numeric value as int64, false if it's not a number or it's a float with a fraction
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	case float32:
+		if v == float32(int64(v)) {
+			return int64(v), true
+		}
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
 // GetAll returns all session's values
 func (s *sessionStore) GetAll() map[string]interface{} {
 	return s.values
@@ -173,7 +463,7 @@ func (s *sessionStore) Set(key string, value interface{}) {
 	s.mu.Lock()
 	s.values[key] = value
 	s.mu.Unlock()
-	s.provider.update(s.sid)
+	s.update()
 }
 
 // Delete removes an entry by its key
@@ -182,7 +472,7 @@ func (s *sessionStore) Delete(key string) {
 	s.mu.Lock()
 	delete(s.values, key)
 	s.mu.Unlock()
-	s.provider.update(s.sid)
+	s.update()
 }
 
 // Clear removes all entries
@@ -192,141 +482,148 @@ func (s *sessionStore) Clear() {
 		delete(s.values, key)
 	}
 	s.mu.Unlock()
-	s.provider.update(s.sid)
+	s.update()
 }
 
-// -------------------------------------------------------------------------------------
-// -------------------------------------------------------------------------------------
-// ----------------------------------sessionProvider implementation---------------------
-// -------------------------------------------------------------------------------------
-// -------------------------------------------------------------------------------------
+// update stores the values to the Backend and the session databases, it's an access of the session too
+func (s *sessionStore) update() {
+	now := time.Now()
+	s.mu.Lock()
+	s.accessed = now
+	sid := s.sid
+	values := copySessionValues(s.values)
+	s.mu.Unlock()
+	values[sessionCreatedKey] = s.created.Unix()
+	values[sessionAccessedKey] = unixMilli(now)
+	s.manager.store(sid, values)
+}
 
-type (
-	// sessionProvider contains the temp sessions memory and the databases
-	sessionProvider struct {
-		mu        sync.Mutex
-		sessions  map[string]*list.Element // underline TEMPORARY memory store used to give advantage on sessions used more times than others
-		list      *list.List               // for GC
-		databases []SessionDatabase
-		expires   time.Duration
-	}
-)
+// popSessionTimes removes the creation and the last access time from the loaded values and returns them
+func popSessionTimes(values map[string]interface{}) (created time.Time, accessed time.Time) {
+	created, accessed = sessionTime(values, sessionCreatedKey, time.Second), sessionTime(values, sessionAccessedKey, time.Millisecond)
+	delete(values, sessionCreatedKey)
+	delete(values, sessionAccessedKey)
+	return
+}
 
-func (p *sessionProvider) registerDatabase(db SessionDatabase) {
-	p.mu.Lock() // for any case
-	p.databases = append(p.databases, db)
-	p.mu.Unlock()
+// sessionTime returns the time of the key, the sessionCreatedKey in seconds or the sessionAccessedKey in milliseconds, of the stored values,
+// the zero time if the values don't have it
+func sessionTime(values map[string]interface{}, key string, unit time.Duration) time.Time {
+	var t int64
+	switch v := values[key].(type) {
+	case int64:
+		t = v
+	case int:
+		t = int64(v)
+	case float64: // i.e decoded from json
+		t = int64(v)
+	default:
+		return time.Time{}
+	}
+	return time.Unix(0, t*int64(unit))
 }
 
-func (p *sessionProvider) newSession(sid string) *sessionStore {
+// unixMilli returns the t as unix milliseconds
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
 
-	sess := &sessionStore{
-		sid:              sid,
-		provider:         p,
-		lastAccessedTime: time.Now(),
-		values:           p.loadSessionValues(sid),
+// copySessionValues returns a shallow copy of the values
+func copySessionValues(values map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		c[k] = v
 	}
-	if p.expires > 0 { // if not unlimited life duration and no -1 (cookie remove action is based on browser's session)
-		time.AfterFunc(p.expires, func() {
-			// the destroy makes the check if this session is exists then or not,
-			// this is used to destroy the session from the server-side also
-			// it's good to have here for security reasons, I didn't add it on the gc function to separate its action
-			p.destroy(sid)
+	return c
+}
 
-		})
+// -------------------------------------------------------------------------------------
+// -------------------------------------------------------------------------------------
+// ----------------------------------memory SessionBackend implementation---------------
+// -------------------------------------------------------------------------------------
+// -------------------------------------------------------------------------------------
+
+type (
+	// memorySessionBackend is the default SessionBackend, it keeps the sessions in memory
+	memorySessionBackend struct {
+		mu       sync.RWMutex
+		sessions map[string]*memorySession
 	}
 
-	return sess
+	// memorySession is a session of the memorySessionBackend
+	memorySession struct {
+		values    map[string]interface{}
+		expiresAt time.Time // zero for never
+	}
+)
 
+// NewMemorySessionBackend returns a SessionBackend which keeps the sessions in memory, it's the default Session.Backend.
+// The sessions are lost when the app restarts, unless a session database keeps them, and they're not shared between the replicas of the app
+func NewMemorySessionBackend() SessionBackend {
+	return &memorySessionBackend{sessions: make(map[string]*memorySession)}
 }
 
-func (p *sessionProvider) loadSessionValues(sid string) map[string]interface{} {
+func (m *memorySession) expired(now time.Time) bool {
+	return !m.expiresAt.IsZero() && now.After(m.expiresAt)
+}
 
-	for i, n := 0, len(p.databases); i < n; i++ {
-		if dbValues := p.databases[i].Load(sid); dbValues != nil && len(dbValues) > 0 {
-			return dbValues // return the first non-empty from the registered stores.
-		}
+func expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
 	}
-	values := make(map[string]interface{})
-	return values
+	return time.Now().Add(ttl)
 }
 
-func (p *sessionProvider) updateDatabases(sid string, newValues map[string]interface{}) {
-	for i, n := 0, len(p.databases); i < n; i++ {
-		p.databases[i].Update(sid, newValues)
+func (b *memorySessionBackend) Get(sid string) (map[string]interface{}, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if sess, found := b.sessions[sid]; found && !sess.expired(time.Now()) {
+		return copySessionValues(sess.values), nil
 	}
+	return nil, nil
 }
 
-// Init creates the session  and returns it
-func (p *sessionProvider) init(sid string) *sessionStore {
-	newSession := p.newSession(sid)
-	elem := p.list.PushBack(newSession)
-	p.mu.Lock()
-	p.sessions[sid] = elem
-	p.mu.Unlock()
-	return newSession
+func (b *memorySessionBackend) Set(sid string, values map[string]interface{}, ttl time.Duration) error {
+	b.mu.Lock()
+	b.sessions[sid] = &memorySession{values: copySessionValues(values), expiresAt: expiresAt(ttl)}
+	b.mu.Unlock()
+	return nil
 }
 
-// Read returns the store which sid parameter is belongs
-func (p *sessionProvider) read(sid string) *sessionStore {
-	p.mu.Lock()
-	if elem, found := p.sessions[sid]; found {
-		p.mu.Unlock() // yes defer is slow
-		elem.Value.(*sessionStore).lastAccessedTime = time.Now()
-		return elem.Value.(*sessionStore)
-	}
-	p.mu.Unlock()
-	// if not found create new
-	sess := p.init(sid)
-	return sess
+func (b *memorySessionBackend) Delete(sid string) error {
+	b.mu.Lock()
+	delete(b.sessions, sid)
+	b.mu.Unlock()
+	return nil
 }
 
-// Destroy destroys the session, removes all sessions values, the session itself and updates the registered session databases, this called from sessionManager which removes the client's cookie also.
-func (p *sessionProvider) destroy(sid string) {
-	p.mu.Lock()
-	if elem, found := p.sessions[sid]; found {
-		sess := elem.Value.(*sessionStore)
-		sess.values = nil
-		p.updateDatabases(sid, nil)
-		delete(p.sessions, sid)
-		p.list.Remove(elem)
+func (b *memorySessionBackend) Touch(sid string, ttl time.Duration) error {
+	b.mu.Lock()
+	if sess, found := b.sessions[sid]; found {
+		sess.expiresAt = expiresAt(ttl)
 	}
-	p.mu.Unlock()
+	b.mu.Unlock()
+	return nil
 }
 
-// Update updates the lastAccessedTime, and moves the memory place element to the front
-// always returns a nil error, for now
-func (p *sessionProvider) update(sid string) {
-	p.mu.Lock()
-	if elem, found := p.sessions[sid]; found {
-		sess := elem.Value.(*sessionStore)
-		sess.lastAccessedTime = time.Now()
-		p.list.MoveToFront(elem)
-		p.updateDatabases(sid, sess.values)
-	}
-	p.mu.Unlock()
+func (b *memorySessionBackend) Expire() error {
+	_, err := b.expireSessions()
+	return err
 }
 
-// GC clears the memory
-func (p *sessionProvider) gc(duration time.Duration) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	for {
-		elem := p.list.Back()
-		if elem == nil {
-			break
-		}
-
-		// if the time has passed. session was expired, then delete the session and its memory place
-		// we are not destroy the session completely for the case this is re-used after
-		sess := elem.Value.(*sessionStore)
-		if time.Now().After(sess.lastAccessedTime.Add(duration)) {
-			p.list.Remove(elem)
-		} else {
-			break
+// expireSessions removes the expired sessions and returns their ids, so the sessionsManager can emit their 'session.expired' events
+func (b *memorySessionBackend) expireSessions() ([]string, error) {
+	var expired []string
+	now := time.Now()
+	b.mu.Lock()
+	for sid, sess := range b.sessions {
+		if sess.expired(now) {
+			delete(b.sessions, sid)
+			expired = append(expired, sid)
 		}
 	}
+	b.mu.Unlock()
+	return expired, nil
 }
 
 // -------------------------------------------------------------------------------------
@@ -337,111 +634,255 @@ func (p *sessionProvider) gc(duration time.Duration) {
 
 type (
 	// sessionsManager implements the ISessionsManager interface
-	// contains the cookie's name, the provider and a duration for GC and cookie life expire
+	// contains the cookie's name, the backend, the session databases and a duration for GC and cookie life expire
 	sessionsManager struct {
-		config   Session
-		provider *sessionProvider
+		config    Session
+		backend   SessionBackend
+		mu        sync.RWMutex
+		databases []SessionDatabase
+		logger    *log.Logger
+		events    EventEmmiter
 	}
 )
 
 // newSessionsManager creates & returns a new SessionsManager and start its GC
-func newSessionsManager(c Session) *sessionsManager {
+func newSessionsManager(c Session, logger *log.Logger, events EventEmmiter) *sessionsManager {
 	if c.DecodeCookie {
 		c.Cookie = base64.URLEncoding.EncodeToString([]byte(c.Cookie)) // change the cookie's name/key to a more safe(?)
 		// get the real value for your tests by:
 		//sessIdKey := url.QueryEscape(base64.URLEncoding.EncodeToString([]byte(Sessions.Cookie)))
 	}
-	manager := &sessionsManager{config: c, provider: &sessionProvider{list: list.New(), sessions: make(map[string]*list.Element, 0), databases: make([]SessionDatabase, 0), expires: c.Expires}}
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = c.Expires
+		if c.IdleTimeout <= 0 {
+			c.IdleTimeout = c.GcDuration
+		}
+	}
+	manager := &sessionsManager{config: c, backend: c.Backend, databases: make([]SessionDatabase, 0), logger: logger, events: events}
 	//run the GC here
 	go manager.gc()
 	return manager
 }
 
 func (m *sessionsManager) registerDatabase(db SessionDatabase) {
-	m.provider.expires = m.config.Expires // updae the expires confiuration field for any case
-	m.provider.registerDatabase(db)
+	m.mu.Lock() // for any case
+	m.databases = append(m.databases, db)
+	m.mu.Unlock()
 }
 
+// generateSessionID returns a new, unpredictable, session id from the crypto/rand
 func (m *sessionsManager) generateSessionID() string {
-	return base64.URLEncoding.EncodeToString(Random(32))
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		m.logger.Printf("Unable to read random bytes for the session id. Trace: %s\n", err)
+		b = Random(32)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
 }
 
-// Start starts the session
-func (m *sessionsManager) start(ctx *Context) *sessionStore {
-	var session *sessionStore
-
-	cookieValue := ctx.GetCookie(m.config.Cookie)
-
-	if cookieValue == "" { // cookie doesn't exists, let's generate a session and add set a cookie
-		sid := m.generateSessionID()
-		session = m.provider.init(sid)
-		//cookie := &http.Cookie{}
-		cookie := AcquireCookie()
-		// The RFC makes no mention of encoding url value, so here I think to encode both sessionid key and the value using the safe(to put and to use as cookie) url-encoding
-		cookie.Name = m.config.Cookie
-		cookie.Value = sid
-		cookie.Path = "/"
-		if !m.config.DisableSubdomainPersistence {
+// accessResolution returns how often the last access time of a session is stored, by its reads, a tenth of the IdleTimeout,
+// the session databases keep it, so a session which is loaded from them is checked for its IdleTimeout too
+func (m *sessionsManager) accessResolution() time.Duration {
+	return m.config.IdleTimeout / 10
+}
+
+// expired returns true if the session of the creation and the last access time is expired by the MaxLifetime or the IdleTimeout,
+// the last access time is stored every accessResolution so that's the tolerance of the IdleTimeout
+func (m *sessionsManager) expired(created time.Time, accessed time.Time) bool {
+	now := time.Now()
+	if m.config.MaxLifetime > 0 && !created.IsZero() && now.After(created.Add(m.config.MaxLifetime)) {
+		return true
+	}
+	return m.config.IdleTimeout > 0 && !accessed.IsZero() && now.Sub(accessed) > m.config.IdleTimeout+m.accessResolution()
+}
+
+// ttl returns the lifetime of a session in the Backend, the IdleTimeout, or less if the session's MaxLifetime ends before that
+func (m *sessionsManager) ttl(created time.Time) time.Duration {
+	ttl := m.config.IdleTimeout
+	if m.config.MaxLifetime > 0 && !created.IsZero() {
+		if remaining := created.Add(m.config.MaxLifetime).Sub(time.Now()); remaining < ttl {
+			ttl = remaining
+		}
+		if ttl <= 0 {
+			// expired, but don't let the backends keep it forever
+			ttl = time.Second
+		}
+	}
+	return ttl
+}
 
-			requestDomain := ctx.Request.Host
-			if portIdx := strings.IndexByte(requestDomain, ':'); portIdx > 0 {
-				requestDomain = requestDomain[0:portIdx]
+// load returns the values of a session from the Backend, or from the first session database which has it, false if none has it
+func (m *sessionsManager) load(sid string) (map[string]interface{}, bool) {
+	values, err := m.backend.Get(sid)
+	if err != nil {
+		m.logger.Printf("Unable to load the session from the backend. Trace: %s\n", err)
+	}
+	if values != nil {
+		return values, true
+	}
+
+	m.mu.RLock()
+	databases := m.databases
+	m.mu.RUnlock()
+	for i, n := 0, len(databases); i < n; i++ {
+		if dbValues := databases[i].Load(sid); len(dbValues) > 0 {
+			values = copySessionValues(dbValues)
+			created := sessionTime(values, sessionCreatedKey, time.Second)
+			if m.expired(created, sessionTime(values, sessionAccessedKey, time.Millisecond)) {
+				// the backend has removed it, don't revive it
+				databases[i].Update(sid, nil)
+				continue
 			}
-			if validCookieDomain(requestDomain) {
-
-				// RFC2109, we allow level 1 subdomains, but no further
-				// if we have localhost.com , we want the localhost.com.
-				// so if we have something like: mysubdomain.localhost.com we want the localhost here
-				// if we have mysubsubdomain.mysubdomain.localhost.com we want the .mysubdomain.localhost.com here
-				// slow things here, especially the 'replace' but this is a good and understable( I hope) way to get the be able to set cookies from subdomains & domain with 1-level limit
-				if dotIdx := strings.LastIndexByte(requestDomain, '.'); dotIdx > 0 {
-					// is mysubdomain.localhost.com || mysubsubdomain.mysubdomain.localhost.com
-					s := requestDomain[0:dotIdx] // set mysubdomain.localhost || mysubsubdomain.mysubdomain.localhost
-					if secondDotIdx := strings.LastIndexByte(s, '.'); secondDotIdx > 0 {
-						//is mysubdomain.localhost ||  mysubsubdomain.mysubdomain.localhost
-						s = s[secondDotIdx+1:] // set to localhost || mysubdomain.localhost
-					}
-					// replace the s with the requestDomain before the domain's siffux
-					subdomainSuff := strings.LastIndexByte(requestDomain, '.')
-					if subdomainSuff > len(s) { // if it is actual exists as subdomain suffix
-						requestDomain = strings.Replace(requestDomain, requestDomain[0:subdomainSuff], s, 1) // set to localhost.com || mysubdomain.localhost.com
-					}
-				}
-				// finally set the .localhost.com (for(1-level) || .mysubdomain.localhost.com (for 2-level subdomain allow)
-				cookie.Domain = "." + requestDomain // . to allow persistance
+			// restore it to the backend, return the first non-empty from the registered databases
+			if err = m.backend.Set(sid, values, m.ttl(created)); err != nil {
+				m.logger.Printf("Unable to restore the session to the backend. Trace: %s\n", err)
 			}
-
+			return values, true
 		}
-		cookie.HttpOnly = true
-		if m.config.Expires == 0 {
-			// unlimited life
-			cookie.Expires = CookieExpireUnlimited
-		} else if m.config.Expires > 0 {
-			cookie.Expires = time.Now().Add(m.config.Expires)
-		} // if it's -1 then the cookie is deleted when the browser closes
-
-		ctx.AddCookie(cookie)
-		ReleaseCookie(cookie)
+	}
+	return nil, false
+}
+
+// store stores the values of a session to the Backend and updates the session databases, nil values destroy the session
+func (m *sessionsManager) store(sid string, values map[string]interface{}) {
+	var err error
+	if values == nil {
+		err = m.backend.Delete(sid)
 	} else {
-		session = m.provider.read(cookieValue)
+		err = m.backend.Set(sid, values, m.ttl(sessionTime(values, sessionCreatedKey, time.Second)))
+	}
+	if err != nil {
+		m.logger.Printf("Unable to store the session to the backend. Trace: %s\n", err)
+	}
+
+	m.mu.RLock()
+	databases := m.databases
+	m.mu.RUnlock()
+	for i, n := 0, len(databases); i < n; i++ {
+		databases[i].Update(sid, values)
+	}
+}
+
+// init creates a new session and stores it
+func (m *sessionsManager) init(sid string) *sessionStore {
+	now := time.Now()
+	sess := &sessionStore{sid: sid, values: make(map[string]interface{}), created: now, accessed: now, manager: m}
+	if err := m.backend.Set(sid, map[string]interface{}{sessionCreatedKey: now.Unix(), sessionAccessedKey: unixMilli(now)}, m.ttl(now)); err != nil {
+		m.logger.Printf("Unable to store the session to the backend. Trace: %s\n", err)
+	}
+	return sess
+}
+
+// read returns the session of the sid, nil if it doesn't exist or it's expired, by the IdleTimeout or the MaxLifetime
+func (m *sessionsManager) read(sid string) *sessionStore {
+	values, found := m.load(sid)
+	if !found {
+		return nil
+	}
+	created, accessed := popSessionTimes(values)
+	if m.expired(created, accessed) {
+		m.store(sid, nil)
+		return nil
+	}
+	if created.IsZero() {
+		// stored before the creation time was kept, its lifetime starts now
+		created = time.Now()
+	}
+	session := &sessionStore{sid: sid, values: values, created: created, accessed: accessed, manager: m}
+	// the session is used, extend its lifetime, store its access time to the session databases too, if it's older than the accessResolution
+	if time.Since(accessed) >= m.accessResolution() {
+		session.update()
+	} else if err := m.backend.Touch(sid, m.ttl(created)); err != nil {
+		m.logger.Printf("Unable to touch the session. Trace: %s\n", err)
 	}
 	return session
 }
 
-// Destroy kills the session and remove the associated cookie
-func (m *sessionsManager) destroy(ctx *Context) {
-	cookieValue := ctx.GetCookie(m.config.Cookie)
-	if cookieValue == "" { // nothing to destroy
+// Start starts the session, the session of the request's cookie or a new one if the cookie is missing, invalid or its session is expired.
+// A new session has always a new id, the ids which the clients send are never adopted, so they can't fixate the session
+func (m *sessionsManager) start(ctx *Context) *sessionStore {
+	if cookieValue := ctx.GetCookie(m.config.Cookie); cookieValue != "" {
+		// if it's forged or tampered, start a new session, its cookie replaces the invalid one
+		if sid, err := ctx.q.cookies.decode(m.config.Cookie, cookieValue); err == nil {
+			if session := m.read(sid); session != nil {
+				return session
+			}
+			m.events.Emit("session.expired", ctx, sid)
+		}
+	}
+
+	// cookie doesn't exists or its session is expired, let's generate a session and add set a cookie
+	sid := m.generateSessionID()
+	session := m.init(sid)
+	m.setCookie(ctx, sid)
+	m.events.Emit("session.created", ctx, sid)
+	return session
+}
+
+// setCookie sets the session cookie of the sid
+func (m *sessionsManager) setCookie(ctx *Context, sid string) {
+	cookieValue, err := ctx.q.cookies.encode(m.config.Cookie, sid)
+	if err != nil {
+		m.logger.Printf("%s\n", err)
 		return
 	}
-	ctx.RemoveCookie(m.config.Cookie)
-	m.provider.destroy(cookieValue)
+	cookie := m.config.acquireCookie(ctx, m.config.Cookie, cookieValue)
+	if m.config.Expires == 0 {
+		// unlimited life
+		cookie.Expires = CookieExpireUnlimited
+	} else if m.config.Expires > 0 {
+		cookie.Expires = time.Now().Add(m.config.Expires)
+	} // if it's -1 then the cookie is deleted when the browser closes
+
+	m.config.addCookie(ctx, cookie)
+	ReleaseCookie(cookie)
+}
+
+// regenerate moves the values of the session to a new id, the old id is destroyed and the cookie is reissued,
+// the session keeps its creation time so its MaxLifetime is not extended
+func (m *sessionsManager) regenerate(ctx *Context, session *sessionStore) {
+	sid := m.generateSessionID()
+	session.mu.Lock()
+	oldSid := session.sid
+	session.sid = sid
+	session.mu.Unlock()
+
+	session.update()
+	m.store(oldSid, nil)
+	m.setCookie(ctx, sid)
+}
+
+// Destroy kills the session and remove the associated cookie
+func (m *sessionsManager) destroy(ctx *Context, session *sessionStore) {
+	if cookieValue := ctx.GetCookie(m.config.Cookie); cookieValue != "" {
+		m.config.removeCookie(ctx, m.config.Cookie)
+	}
+	sid := session.ID()
+	m.store(sid, nil)
+	m.events.Emit("session.destroyed", ctx, sid)
+}
+
+// sessionsExpirer is implemented by the backends which report the ids of the sessions which their Expire removes, i.e the memory one
+type sessionsExpirer interface {
+	expireSessions() ([]string, error)
 }
 
 // GC tick-tock for the store cleanup
 // it's a blocking function, so run it with go routine, it's totally safe
 func (m *sessionsManager) gc() {
-	m.provider.gc(m.config.GcDuration)
+	if expirer, ok := m.backend.(sessionsExpirer); ok {
+		expired, err := expirer.expireSessions()
+		if err != nil {
+			m.logger.Printf("Unable to remove the expired sessions. Trace: %s\n", err)
+		}
+		for _, sid := range expired {
+			// remove it from the session databases too, there is no request, the ctx is nil
+			m.store(sid, nil)
+			m.events.Emit("session.expired", nil, sid)
+		}
+	} else if err := m.backend.Expire(); err != nil {
+		m.logger.Printf("Unable to remove the expired sessions. Trace: %s\n", err)
+	}
 	// set a timer for the next GC
 	time.AfterFunc(m.config.GcDuration, func() {
 		m.gc()
@@ -0,0 +1,407 @@
+package q
+
+import (
+	"encoding/gob"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mirrorDB struct {
+	mu   sync.Mutex
+	data map[string]map[string]interface{}
+}
+
+func (m *mirrorDB) Load(sid string) map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.data[sid]
+}
+
+func (m *mirrorDB) Update(sid string, v map[string]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v == nil {
+		delete(m.data, sid)
+		return
+	}
+	m.data[sid] = v
+}
+
+func sessionQ(s Session) *Q {
+	return (&Q{Host: "mydomain.com:80", DisableServer: true, Session: s, Request: Request{Entries: Entries{
+		Entry{Method: "GET", Path: "/set", Handler: func(ctx *Context) { ctx.Session().Set("name", ctx.URLParam("v")) }},
+		Entry{Method: "GET", Path: "/get", Handler: func(ctx *Context) { ctx.WriteString("%v", ctx.Session().GetString("name")) }},
+		Entry{Method: "GET", Path: "/destroy", Handler: func(ctx *Context) { ctx.SessionDestroy() }},
+	}}}).Go()
+}
+
+// sreq serves a GET request of the target with the cookies.
+func sreq(qq *Q, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	var headers []string
+	for _, c := range cookies {
+		headers = append(headers, "Cookie", c.Name+"="+c.Value)
+	}
+	return serve(qq, "GET", target, headers...)
+}
+
+func sessionCookie(t *testing.T, rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range (&http.Response{Header: rec.Header()}).Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("no cookie %q in %v", name, rec.Header())
+	return nil
+}
+
+func TestSessionBackendShared(t *testing.T) {
+	backend := NewMemorySessionBackend()
+	mirror := &mirrorDB{data: map[string]map[string]interface{}{}}
+	a := sessionQ(Session{Cookie: "sid", Backend: backend, Databases: Databases{mirror}})
+	b := sessionQ(Session{Cookie: "sid", Backend: backend})
+	c := sessionCookie(t, sreq(a, "/set?v=gopher"), "sid")
+	if rec := sreq(b, "/get", c); rec.Body.String() != "gopher" {
+		t.Errorf("replica got %q", rec.Body.String())
+	}
+	if mirror.data[c.Value]["name"] != "gopher" {
+		t.Errorf("mirror %v", mirror.data)
+	}
+	// lost by the backend, restored from the mirror
+	backend.Delete(c.Value)
+	if rec := sreq(a, "/get", c); rec.Body.String() != "gopher" {
+		t.Errorf("restore got %q", rec.Body.String())
+	}
+	sreq(a, "/destroy", c)
+	if rec := sreq(b, "/get", c); rec.Body.String() != "" {
+		t.Errorf("destroyed got %q", rec.Body.String())
+	}
+	if _, ok := mirror.data[c.Value]; ok {
+		t.Errorf("mirror not destroyed")
+	}
+	if !strings.Contains(c.String(), "HttpOnly") {
+		t.Errorf("cookie %s", c)
+	}
+}
+
+func TestMemoryBackendTTL(t *testing.T) {
+	b := NewMemorySessionBackend()
+	b.Set("x", map[string]interface{}{"a": 1}, 20*time.Millisecond)
+	if v, _ := b.Get("x"); v["a"] != 1 {
+		t.Fatal(v)
+	}
+	time.Sleep(12 * time.Millisecond)
+	b.Touch("x", 20*time.Millisecond)
+	time.Sleep(12 * time.Millisecond)
+	if v, _ := b.Get("x"); v == nil {
+		t.Fatal("touch didn't extend")
+	}
+	time.Sleep(25 * time.Millisecond)
+	if v, _ := b.Get("x"); v != nil {
+		t.Fatal("not expired")
+	}
+	b.Expire()
+	if len(b.(*memorySessionBackend).sessions) != 0 {
+		t.Fatal("expire didn't remove")
+	}
+}
+
+func TestSessionCookieKeys(t *testing.T) {
+	k1 := []byte("0123456789abcdef0123456789abcdef")
+	k2 := []byte("fedcba9876543210fedcba9876543210")
+	for _, enc := range []bool{false, true} {
+		backend := NewMemorySessionBackend()
+		old := sessionQ(Session{Cookie: "sid", Keys: [][]byte{k1}, Encrypt: enc, Backend: backend})
+		c := sessionCookie(t, sreq(old, "/set?v=gopher"), "sid")
+		if rec := sreq(old, "/get", c); rec.Body.String() != "gopher" {
+			t.Fatalf("enc=%v got %q", enc, rec.Body.String())
+		}
+		// rotation, same backend
+		rotated := sessionQ(Session{Cookie: "sid", Keys: [][]byte{k2, k1}, Encrypt: enc, Backend: backend})
+		if rec := sreq(rotated, "/get", c); rec.Body.String() != "gopher" {
+			t.Fatalf("rotated enc=%v got %q", enc, rec.Body.String())
+		}
+		// tamper
+		bad := &http.Cookie{Name: "sid", Value: c.Value[:len(c.Value)-2] + "AA"}
+		rec := sreq(old, "/get", bad)
+		if rec.Body.String() != "" {
+			t.Fatalf("tampered got %q", rec.Body.String())
+		}
+		if nc := sessionCookie(t, rec, "sid"); nc.Value == bad.Value {
+			t.Fatal("no new cookie")
+		}
+	}
+	if err := (&Q{Host: "a.com:80", DisableServer: true, Session: Session{Cookie: "sid", Keys: [][]byte{[]byte("short")}}}).Build(); err == nil {
+		t.Fatal("expected short key err")
+	}
+}
+
+func TestFlashSigned(t *testing.T) {
+	qq := (&Q{Host: "mydomain.com:80", DisableServer: true, Session: Session{Keys: [][]byte{[]byte("0123456789abcdef0123456789abcdef")}}, Request: Request{Entries: Entries{
+		Entry{Method: "GET", Path: "/set", Handler: func(ctx *Context) { ctx.SetFlash("msg", "hello") }},
+		Entry{Method: "GET", Path: "/get", Handler: func(ctx *Context) { v, _ := ctx.GetFlash("msg"); ctx.WriteString("%s", v) }},
+	}}}).Go()
+	c := sessionCookie(t, sreq(qq, "/set"), "_q_flash_message_msg")
+	if rec := sreq(qq, "/get", c); rec.Body.String() != "hello" {
+		t.Fatalf("flash got %q", rec.Body.String())
+	}
+	forged := &http.Cookie{Name: c.Name, Value: encodeCookieValue("evil")}
+	if rec := sreq(qq, "/get", forged); rec.Body.String() != "" {
+		t.Fatalf("forged flash got %q", rec.Body.String())
+	}
+}
+
+func TestSessionRegenerateLifetimesEvents(t *testing.T) {
+	var created, expired, destroyed []string
+	backend := NewMemorySessionBackend()
+	qq := (&Q{Host: "mydomain.com:80", DisableServer: true, Session: Session{Cookie: "sid", Backend: backend, IdleTimeout: 2 * time.Second, MaxLifetime: 3 * time.Second},
+		Events: Events{
+			"session.created":   EventListeners{func(d ...interface{}) { created = append(created, d[1].(string)) }},
+			"session.expired":   EventListeners{func(d ...interface{}) { expired = append(expired, d[1].(string)) }},
+			"session.destroyed": EventListeners{func(d ...interface{}) { destroyed = append(destroyed, d[1].(string)) }},
+		},
+		Request: Request{Entries: Entries{
+			Entry{Method: "GET", Path: "/set", Handler: func(ctx *Context) { ctx.Session().Set("name", ctx.URLParam("v")) }},
+			Entry{Method: "GET", Path: "/get", Handler: func(ctx *Context) { ctx.WriteString("%v", ctx.Session().GetString("name")) }},
+			Entry{Method: "GET", Path: "/login", Handler: func(ctx *Context) { ctx.SessionRegenerate(); ctx.WriteString("%s", ctx.Session().ID()) }},
+			Entry{Method: "GET", Path: "/all", Handler: func(ctx *Context) { ctx.WriteString("%d", len(ctx.Session().GetAll())) }},
+			Entry{Method: "GET", Path: "/destroy", Handler: func(ctx *Context) { ctx.SessionDestroy() }},
+		}}}).Go()
+
+	// fixation: unknown sid is not adopted
+	rec := sreq(qq, "/set?v=x", &http.Cookie{Name: "sid", Value: "attacker"})
+	c := sessionCookie(t, rec, "sid")
+	if c.Value == "attacker" || len(expired) != 1 || len(created) != 1 {
+		t.Fatalf("fixation %v %v %v", c.Value, expired, created)
+	}
+	if rec := sreq(qq, "/all", c); rec.Body.String() != "1" {
+		t.Fatalf("meta leaked: %s", rec.Body.String())
+	}
+	rec = sreq(qq, "/login", c)
+	nc := sessionCookie(t, rec, "sid")
+	if nc.Value == c.Value || rec.Body.String() != nc.Value {
+		t.Fatalf("regenerate %v %v %s", c.Value, nc.Value, rec.Body.String())
+	}
+	if v, _ := backend.Get(c.Value); v != nil {
+		t.Fatal("old sid still there")
+	}
+	if rec := sreq(qq, "/get", nc); rec.Body.String() != "x" {
+		t.Fatalf("moved values %q", rec.Body.String())
+	}
+	// idle keeps alive, absolute ends it
+	time.Sleep(1500 * time.Millisecond)
+	if rec := sreq(qq, "/get", nc); rec.Body.String() != "x" {
+		t.Fatalf("idle alive %q", rec.Body.String())
+	}
+	time.Sleep(1600 * time.Millisecond)
+	if rec := sreq(qq, "/get", nc); rec.Body.String() != "" {
+		t.Fatalf("absolute %q", rec.Body.String())
+	}
+	if len(expired) != 2 {
+		t.Fatalf("expired events %v", expired)
+	}
+	c = sessionCookie(t, sreq(qq, "/set?v=y"), "sid")
+	sreq(qq, "/destroy", c)
+	if len(destroyed) != 1 || destroyed[0] != c.Value {
+		t.Fatalf("destroyed %v", destroyed)
+	}
+}
+
+func TestSessionIdle(t *testing.T) {
+	qq := sessionQ(Session{Cookie: "sid", IdleTimeout: time.Second})
+	c := sessionCookie(t, sreq(qq, "/set?v=z"), "sid")
+	time.Sleep(1200 * time.Millisecond)
+	if rec := sreq(qq, "/get", c); rec.Body.String() != "" {
+		t.Fatalf("idle %q", rec.Body.String())
+	}
+}
+
+// suffixList is a cookiejar.PublicSuffixList of a few suffixes, the tests don't depend on the golang.org/x/net/publicsuffix
+type suffixList []string
+
+func (l suffixList) PublicSuffix(domain string) string {
+	for _, suffix := range l {
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return suffix
+		}
+	}
+	return domain[strings.LastIndexByte(domain, '.')+1:]
+}
+
+func (l suffixList) String() string { return "test" }
+
+func TestSessionCookieOptions(t *testing.T) {
+	suffixes := suffixList{"co.uk", "com"}
+	qq := (&Q{Host: "www.mydomain.co.uk:80", DisableServer: true, Session: Session{Cookie: "sid", Secure: true, SameSite: SameSiteStrict, PublicSuffixList: suffixes}, Request: Request{Entries: Entries{
+		Entry{Method: "GET", Path: "/set", Handler: func(ctx *Context) { ctx.Session().Set("a", 1); ctx.SetFlash("msg", "hi") }},
+		Entry{Method: "GET", Path: "/destroy", Handler: func(ctx *Context) { ctx.SessionDestroy() }},
+	}}}).Go()
+	rec := sreq(qq, "http://www.mydomain.co.uk/set")
+	sc := rec.Header()["Set-Cookie"]
+	if len(sc) != 2 {
+		t.Fatalf("%v", sc)
+	}
+	for _, v := range sc {
+		if !strings.Contains(v, "Domain=mydomain.co.uk") || !strings.Contains(v, "Secure") || !strings.HasSuffix(v, "; SameSite=Strict") || !strings.Contains(v, "HttpOnly") || !strings.Contains(v, "Path=/") || strings.Contains(v, "Max-Age") {
+			t.Errorf("bad cookie %q", v)
+		}
+	}
+	c := sessionCookie(t, rec, "sid")
+	rec = sreq(qq, "http://www.mydomain.co.uk/destroy", c)
+	if v := rec.Header().Get("Set-Cookie"); !strings.Contains(v, "Max-Age=0") || !strings.Contains(v, "Domain=mydomain.co.uk") {
+		t.Errorf("removal %q", v)
+	}
+
+	q2 := (&Q{Host: "x.com:80", DisableServer: true, Session: Session{Cookie: "sid", PublicSuffixList: suffixes}, Request: Request{Entries: Entries{
+		Entry{Method: "GET", Path: "/", Handler: func(ctx *Context) { ctx.Session() }}}}}).Go()
+	for host, want := range map[string]string{"co.uk": "", "localhost": "", "127.0.0.1": "", "a.b.example.com": "example.com", "[::1]:80": ""} {
+		v := serve(q2, "GET", "/", "Host", host).Header().Get("Set-Cookie")
+		if (want == "" && strings.Contains(v, "Domain")) || (want != "" && !strings.Contains(v, "Domain="+want)) {
+			t.Errorf("%s: %q", host, v)
+		}
+	}
+	// the public suffix list is opt-in, the cookies are host-only without it
+	q3 := (&Q{Host: "www.example.com:80", DisableServer: true, Session: Session{Cookie: "sid"}, Request: Request{Entries: Entries{
+		Entry{Method: "GET", Path: "/", Handler: func(ctx *Context) { ctx.Session() }}}}}).Go()
+	if v := sreq(q3, "http://www.example.com/").Header().Get("Set-Cookie"); v == "" || strings.Contains(v, "Domain") {
+		t.Errorf("host-only %q", v)
+	}
+	if err := (&Q{Host: "a.com:80", DisableServer: true, Session: Session{SameSite: "lax"}}).Build(); err == nil {
+		t.Error("expected samesite error")
+	}
+}
+
+type codecDB struct {
+	mirrorDB
+	codec  SessionCodec
+	logger interface{}
+	raw    map[string][]byte
+}
+
+func (c *codecDB) SetCodec(codec SessionCodec) { c.codec = codec }
+func (c *codecDB) Update(sid string, v map[string]interface{}) {
+	b, err := c.codec.Encode(v)
+	if err != nil {
+		panic(err)
+	}
+	c.raw[sid] = b
+}
+func (c *codecDB) Load(sid string) map[string]interface{} {
+	if b, ok := c.raw[sid]; ok {
+		v, _ := c.codec.Decode(b)
+		return v
+	}
+	return nil
+}
+
+type sUser struct {
+	Name string
+	Age  int
+}
+
+func TestSessionCodecsAndGetters(t *testing.T) {
+	gob.Register(map[string]interface{}{})
+	for _, codec := range []SessionCodec{GobSessionCodec, JSONSessionCodec} {
+		db := &codecDB{raw: map[string][]byte{}}
+		mk := func() *Q {
+			return (&Q{Host: "mydomain.com:80", DisableServer: true, Session: Session{Cookie: "sid", Codec: codec, Databases: Databases{db}}, Request: Request{Entries: Entries{
+				Entry{Method: "GET", Path: "/set", Handler: func(ctx *Context) {
+					s := ctx.Session()
+					s.Set("i", 42)
+					s.Set("i64", int64(7))
+					s.Set("f", 1.5)
+					s.Set("b", true)
+					s.Set("u", map[string]interface{}{"Name": "gopher", "Age": 9})
+				}},
+				Entry{Method: "GET", Path: "/get", Handler: func(ctx *Context) {
+					s := ctx.Session()
+					var u sUser
+					err := s.Decode("u", &u)
+					var missing int
+					merr := s.Decode("nope", &missing)
+					ctx.WriteString("%d %d %v %v %v %v %v", s.GetInt("i"), s.GetInt64("i64"), s.GetFloat64("f"), s.GetBool("b"), u, err, merr != nil)
+				}},
+			}}}).Go()
+		}
+		a := mk()
+		c := sessionCookie(t, sreq(a, "/set"), "sid")
+		if db.codec != codec {
+			t.Fatal("codec not passed")
+		}
+		// a fresh instance (restart) loads from the db
+		b := mk()
+		if rec := sreq(b, "/get", c); rec.Body.String() != "42 7 1.5 true {gopher 9} <nil> true" {
+			t.Errorf("%T: %q", codec, rec.Body.String())
+		}
+	}
+}
+
+func TestSessionMirrorIdle(t *testing.T) {
+	var expired []string
+	backend := NewMemorySessionBackend()
+	mirror := &mirrorDB{data: map[string]map[string]interface{}{}}
+	qq := (&Q{Host: "mydomain.com:80", DisableServer: true,
+		Session: Session{Cookie: "sid", Backend: backend, Databases: Databases{mirror}, IdleTimeout: time.Second},
+		Events:  Events{"session.expired": EventListeners{func(d ...interface{}) { expired = append(expired, d[1].(string)) }}},
+		Request: Request{Entries: Entries{
+			Entry{Method: "GET", Path: "/set", Handler: func(ctx *Context) { ctx.Session().Set("name", ctx.URLParam("v")) }},
+			Entry{Method: "GET", Path: "/get", Handler: func(ctx *Context) { ctx.WriteString("%v", ctx.Session().GetString("name")) }},
+		}}}).Go()
+
+	c := sessionCookie(t, sreq(qq, "/set?v=gopher"), "sid")
+	// the reads store the access time to the mirror, so an active session is restored
+	time.Sleep(700 * time.Millisecond)
+	if rec := sreq(qq, "/get", c); rec.Body.String() != "gopher" {
+		t.Fatalf("got %q", rec.Body.String())
+	}
+	time.Sleep(700 * time.Millisecond)
+	backend.Delete(c.Value)
+	if rec := sreq(qq, "/get", c); rec.Body.String() != "gopher" {
+		t.Fatalf("active session not restored, got %q", rec.Body.String())
+	}
+
+	// idle, expired by the backend, the mirror doesn't revive it
+	time.Sleep(1300 * time.Millisecond)
+	if v, _ := backend.Get(c.Value); v != nil {
+		t.Fatalf("the backend keeps the idle session")
+	}
+	if mirror.Load(c.Value) == nil {
+		t.Fatalf("the mirror should keep the session until it's read")
+	}
+	if rec := sreq(qq, "/get", c); rec.Body.String() != "" {
+		t.Fatalf("idle session restored from the mirror, got %q", rec.Body.String())
+	}
+	if mirror.Load(c.Value) != nil {
+		t.Fatalf("the expired session is not removed from the mirror")
+	}
+	if len(expired) != 1 || expired[0] != c.Value {
+		t.Fatalf("expired events %v", expired)
+	}
+}
+
+func TestSessionGCExpiredEvent(t *testing.T) {
+	expired := make(chan []interface{}, 1)
+	mirror := &mirrorDB{data: map[string]map[string]interface{}{}}
+	qq := (&Q{Host: "mydomain.com:80", DisableServer: true,
+		Session: Session{Cookie: "sid", Databases: Databases{mirror}, IdleTimeout: 30 * time.Millisecond, GcDuration: 50 * time.Millisecond},
+		Events:  Events{"session.expired": EventListeners{func(d ...interface{}) { expired <- d }}},
+		Request: Request{Entries: Entries{
+			Entry{Method: "GET", Path: "/set", Handler: func(ctx *Context) { ctx.Session().Set("name", "gopher") }},
+		}}}).Go()
+
+	c := sessionCookie(t, sreq(qq, "/set"), "sid")
+	select {
+	case d := <-expired:
+		if d[0] != nil || d[1] != c.Value {
+			t.Fatalf("event data %v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no session.expired event by the gc")
+	}
+	if mirror.Load(c.Value) != nil {
+		t.Fatalf("the expired session is not removed from the mirror")
+	}
+}
@@ -0,0 +1,67 @@
+package q
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"log"
+)
+
+type (
+	// SessionCodec serializes the values of the sessions for the Session.Backend and the Session.Databases which store them out of the process, i.e the redis,
+	// it's the Session.Codec, look the GobSessionCodec, the JSONSessionCodec and the opt-in sessioncodec/msgpack
+	SessionCodec interface {
+		// Encode returns the serialized values
+		Encode(values map[string]interface{}) ([]byte, error)
+		// Decode returns the values of the serialized data
+		Decode(data []byte) (map[string]interface{}, error)
+	}
+
+	// SessionCodecSetter is implemented by the session databases and the backends which serialize the values,
+	// the Session.Codec is passed to them by the SetCodec when q is built
+	SessionCodecSetter interface {
+		SetCodec(SessionCodec)
+	}
+
+	// SessionLoggerSetter is implemented by the session databases and the backends which report their errors,
+	// the Q.Logger is passed to them by the SetLogger when q is built, the errors of the Load and the Update can't be returned
+	SessionLoggerSetter interface {
+		SetLogger(*log.Logger)
+	}
+
+	gobSessionCodec  struct{}
+	jsonSessionCodec struct{}
+)
+
+var (
+	// GobSessionCodec serializes the values by the encoding/gob, it's the default Session.Codec,
+	// the custom types of the values should be registered by the gob.Register, otherwise their sessions can't be stored
+	GobSessionCodec SessionCodec = gobSessionCodec{}
+	// JSONSessionCodec serializes the values by the encoding/json, the values are decoded as the json's types,
+	// i.e the numbers as float64 and the structs as map[string]interface{}, use the typed getters and the Decode of the SessionStore
+	JSONSessionCodec SessionCodec = jsonSessionCodec{}
+)
+
+func (gobSessionCodec) Encode(values map[string]interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobSessionCodec) Decode(data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values)
+	return values, err
+}
+
+func (jsonSessionCodec) Encode(values map[string]interface{}) ([]byte, error) {
+	return json.Marshal(values)
+}
+
+func (jsonSessionCodec) Decode(data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	err := json.Unmarshal(data, &values)
+	return values, err
+}
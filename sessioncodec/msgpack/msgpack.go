@@ -0,0 +1,48 @@
+// Package msgpack is the opt-in MessagePack q.SessionCodec, by the gopkg.in/vmihailenco/msgpack.v2, it's more compact than the q.JSONSessionCodec,
+// i.e q.Session{Cookie: "mysessionid", Codec: msgpack.Codec, Backend: redis.New()}
+package msgpack
+
+import (
+	"fmt"
+
+	"github.com/kataras/q"
+	"gopkg.in/vmihailenco/msgpack.v2"
+)
+
+type codec struct{}
+
+// Codec serializes the values of the sessions by the MessagePack,
+// the values are decoded as the msgpack's types, i.e the integers as int64, use the typed getters and the Decode of the q.SessionStore
+var Codec q.SessionCodec = codec{}
+
+func (codec) Encode(values map[string]interface{}) ([]byte, error) {
+	return msgpack.Marshal(values)
+}
+
+func (codec) Decode(data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if err := msgpack.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	for k, v := range values {
+		values[k] = stringKeys(v)
+	}
+	return values, nil
+}
+
+// stringKeys converts the map[interface{}]interface{}, of the decoded msgpack maps, to map[string]interface{}, like the json's ones
+func stringKeys(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, e := range value {
+			m[fmt.Sprintf("%v", k)] = stringKeys(e)
+		}
+		return m
+	case []interface{}:
+		for i := range value {
+			value[i] = stringKeys(value[i])
+		}
+	}
+	return v
+}
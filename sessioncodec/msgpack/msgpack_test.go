@@ -0,0 +1,34 @@
+package msgpack
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCodec(t *testing.T) {
+	data, err := Codec.Encode(map[string]interface{}{"name": "gopher", "user": map[string]interface{}{"name": "q"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := Codec.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["name"] != "gopher" {
+		t.Fatalf("name %v", values["name"])
+	}
+	if user, ok := values["user"].(map[string]interface{}); !ok || user["name"] != "q" {
+		t.Fatalf("user %#v", values["user"])
+	}
+	if _, err = Codec.Decode([]byte{0xc1}); err == nil {
+		t.Fatal("expected an error of the invalid data")
+	}
+}
+
+func TestStringKeys(t *testing.T) {
+	v := stringKeys([]interface{}{map[interface{}]interface{}{"a": map[interface{}]interface{}{int64(1): "b"}}})
+	want := []interface{}{map[string]interface{}{"a": map[string]interface{}{"1": "b"}}}
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("got %#v", v)
+	}
+}
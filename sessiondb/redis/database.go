@@ -3,18 +3,40 @@ package redis
 import (
 	"bytes"
 	"encoding/gob"
+	"log"
+	"os"
+	"sync"
+	"time"
 
+	"github.com/kataras/q"
+	"github.com/kataras/q/errors"
 	"github.com/kataras/q/sessiondb/redis/service"
 )
 
-// Database the redis database for q sessions
+var errRedisPong = errors.New("Redis didn't reply to the PING with a PONG")
+
+// Database the redis database for q sessions, it's a session database, a mirror, and a q.SessionBackend, the Session.Backend,
+// which is shared by the replicas of the app
 type Database struct {
-	redis *service.Service
+	redis  *service.Service
+	mu     sync.Mutex
+	codec  q.SessionCodec
+	logger *log.Logger
 }
 
-// New returns a new redis database
+// New returns a new redis database, its values are serialized by the q.GobSessionCodec until the Session.Codec is passed by the SetCodec
 func New(cfg ...service.Config) *Database {
-	return &Database{redis: service.New(cfg...)}
+	return &Database{redis: service.New(cfg...), codec: q.GobSessionCodec, logger: log.New(os.Stdout, "[Q] ", log.LstdFlags)}
+}
+
+// SetCodec sets the codec of the values, it's called with the Session.Codec when q is built, implements the q.SessionCodecSetter
+func (d *Database) SetCodec(codec q.SessionCodec) {
+	d.codec = codec
+}
+
+// SetLogger sets the logger of the Load's and the Update's errors, it's called with the Q.Logger when q is built, implements the q.SessionLoggerSetter
+func (d *Database) SetLogger(logger *log.Logger) {
+	d.logger = logger
 }
 
 // Config returns the configuration for the redis server bridge, you can change them
@@ -22,49 +44,126 @@ func (d *Database) Config() *service.Config {
 	return d.redis.Config
 }
 
+// connect connects to the redis, if not connected before, returns the ping's error,
+// it's Connected only after a successful ping, otherwise the next call connects again
+func (d *Database) connect() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.redis.Connected {
+		return nil
+	}
+	d.redis.Connect()
+	pong, err := d.redis.PingPong()
+	if err == nil && !pong {
+		err = errRedisPong
+	}
+	if err != nil {
+		d.redis.CloseConnection()
+		return err
+	}
+	d.redis.Connected = true
+	return nil
+}
+
 // Load loads the values to the underline
 func (d *Database) Load(sid string) map[string]interface{} {
-	values := make(map[string]interface{})
-
-	if !d.redis.Connected { //yes, check every first time's session for valid redis connection
-		d.redis.Connect()
-		_, err := d.redis.PingPong()
-		if err != nil {
-			if err != nil {
-				// don't use to get the logger, just prin these to the console... atm
-				println("Redis Connection error on Connect: " + err.Error())
-				println("But don't panic, auto-switching to memory store right now!")
-			}
-		}
+	//yes, check every first time's session for valid redis connection
+	if err := d.connect(); err != nil {
+		d.logger.Printf("Redis connection error on Connect, the session: '%s' can't be loaded. Trace: %s\n", sid, err)
+		return nil
 	}
 	//fetch the values from this session id and copy-> store them
-	val, err := d.redis.GetBytes(sid)
-	if err == nil {
-		err = DeserializeBytes(val, &values)
+	val, err := d.redis.Lookup(sid)
+	if err != nil {
+		d.logger.Printf("Unable to load the session: '%s' from the redis. Trace: %s\n", sid, err)
+		return nil
+	}
+	if val == nil {
+		return nil
+	}
+	values, err := d.codec.Decode(val)
+	if err != nil {
+		d.logger.Printf("Unable to decode the session: '%s'. Trace: %s\n", sid, err)
+		return nil
 	}
-
 	return values
+}
+
+// Update updates the real redis store
+func (d *Database) Update(sid string, newValues map[string]interface{}) {
+	if len(newValues) == 0 {
+		go func() {
+			if err := d.redis.Delete(sid); err != nil {
+				d.logger.Printf("Unable to delete the session: '%s' from the redis. Trace: %s\n", sid, err)
+			}
+		}()
+		return
+	}
+	// serialize the values here, the newValues may change after the return
+	val, err := d.codec.Encode(newValues)
+	if err != nil {
+		d.logger.Printf("Unable to encode the session: '%s', it's not stored. Trace: %s\n", sid, err)
+		return
+	}
+	go func() {
+		if err := d.redis.Set(sid, val); err != nil { //set/update all the values
+			d.logger.Printf("Unable to store the session: '%s' to the redis. Trace: %s\n", sid, err)
+		}
+	}()
+}
 
+// ttlSeconds returns the ttl in seconds, rounded up, 0 for the Config.MaxAgeSeconds
+func ttlSeconds(ttl time.Duration) int {
+	if ttl <= 0 {
+		return 0
+	}
+	return int((ttl + time.Second - 1) / time.Second)
 }
 
-// serialize the values to be stored as strings inside the Redis, we panic at any serialization error here
-func serialize(values map[string]interface{}) []byte {
-	val, err := SerializeBytes(values)
+// Get returns the values of a session, nil if it doesn't exist or it's expired, implements the q.SessionBackend
+func (d *Database) Get(sid string) (map[string]interface{}, error) {
+	if err := d.connect(); err != nil {
+		return nil, err
+	}
+	val, err := d.redis.Lookup(sid)
+	if err != nil || val == nil {
+		// val is nil if the session doesn't exist or it's expired
+		return nil, err
+	}
+	return d.codec.Decode(val)
+}
+
+// Set stores the values of a session with a ttl, 0 for the Config.MaxAgeSeconds, implements the q.SessionBackend
+func (d *Database) Set(sid string, values map[string]interface{}, ttl time.Duration) error {
+	if err := d.connect(); err != nil {
+		return err
+	}
+	val, err := d.codec.Encode(values)
 	if err != nil {
-		println("On redisstore.serialize: " + err.Error())
+		return err
 	}
+	return d.redis.SetTTL(sid, val, ttlSeconds(ttl))
+}
 
-	return val
+// Delete removes a session, implements the q.SessionBackend
+func (d *Database) Delete(sid string) error {
+	if err := d.connect(); err != nil {
+		return err
+	}
+	return d.redis.Delete(sid)
 }
 
-// Update updates the real redis store
-func (d *Database) Update(sid string, newValues map[string]interface{}) {
-	if len(newValues) == 0 {
-		go d.redis.Delete(sid)
-	} else {
-		go d.redis.Set(sid, serialize(newValues)) //set/update all the values
+// Touch extends the expiration of a session by the ttl, 0 for the Config.MaxAgeSeconds, implements the q.SessionBackend
+func (d *Database) Touch(sid string, ttl time.Duration) error {
+	if err := d.connect(); err != nil {
+		return err
 	}
+	return d.redis.Expire(sid, ttlSeconds(ttl))
+}
 
+// Expire does nothing, the redis expires the sessions by their own, implements the q.SessionBackend
+func (d *Database) Expire() error {
+	return nil
 }
 
 // SerializeBytes serializa bytes using gob encoder and returns them
@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kataras/q/sessiondb/redis/service"
+)
+
+// fakeRedis replies to each command with a PONG, or with an error while it's down
+func fakeRedis(t *testing.T, down *int32) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				buf := make([]byte, 1024)
+				for {
+					if _, err := r.Read(buf); err != nil {
+						return
+					}
+					reply := "+PONG\r\n"
+					if atomic.LoadInt32(down) == 1 {
+						reply = "-ERR down\r\n"
+					}
+					conn.Write([]byte(reply))
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestConnect(t *testing.T) {
+	down := int32(1)
+	d := New(service.Config{Addr: fakeRedis(t, &down)})
+	if err := d.connect(); err == nil {
+		t.Fatal("expected the ping's error")
+	}
+	if d.redis.Connected {
+		t.Fatal("connected after a failed ping")
+	}
+
+	atomic.StoreInt32(&down, 0)
+	if err := d.connect(); err != nil {
+		t.Fatal(err)
+	}
+	if !d.redis.Connected {
+		t.Fatal("not connected after a successful ping")
+	}
+}
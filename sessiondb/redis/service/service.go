@@ -16,7 +16,8 @@ var (
 
 // Service the Redis service, contains the config and the redis pool
 type Service struct {
-	// Connected is true when the Service has already connected
+	// Connected is true when the Service has already connected and its ping has succeeded,
+	// it's set by the caller of the Connect after a successful PingPong, i.e by the redis.Database
 	Connected bool
 	// Config the redis config for this redis
 	Config *Config
@@ -54,6 +55,34 @@ func (r *Service) Set(key string, value []byte) (err error) { // map[interface{}
 	return
 }
 
+// SetTTL sets to the redis with a ttl in seconds, a ttl <= 0 means the Config.MaxAgeSeconds
+func (r *Service) SetTTL(key string, value []byte, ttlSeconds int) (err error) {
+	if ttlSeconds <= 0 {
+		ttlSeconds = r.Config.MaxAgeSeconds
+	}
+	c := r.pool.Get()
+	defer c.Close()
+	if err = c.Err(); err != nil {
+		return
+	}
+	_, err = c.Do("SETEX", r.Config.Prefix+key, ttlSeconds, value)
+	return
+}
+
+// Expire sets the ttl in seconds of a key, a ttl <= 0 means the Config.MaxAgeSeconds
+func (r *Service) Expire(key string, ttlSeconds int) (err error) {
+	if ttlSeconds <= 0 {
+		ttlSeconds = r.Config.MaxAgeSeconds
+	}
+	c := r.pool.Get()
+	defer c.Close()
+	if err = c.Err(); err != nil {
+		return
+	}
+	_, err = c.Do("EXPIRE", r.Config.Prefix+key, ttlSeconds)
+	return
+}
+
 // Get returns value, err by its key
 // you can use utils.Deserialize((.Get("yourkey"),&theobject{})
 //returns nil and a filled error if something wrong happens
@@ -97,6 +126,21 @@ func (r *Service) GetBytes(key string) ([]byte, error) {
 	return redis.Bytes(redisVal, err)
 }
 
+// Lookup returns the value by its key, nil and a nil error if the key doesn't exist
+func (r *Service) Lookup(key string) ([]byte, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+
+	redisVal, err := c.Do("GET", r.Config.Prefix+key)
+	if err != nil || redisVal == nil {
+		return nil, err
+	}
+	return redis.Bytes(redisVal, err)
+}
+
 // GetString returns value, err by its key
 // you can use utils.Deserialize((.GetString("yourkey"),&theobject{})
 //returns empty string and a filled error if something wrong happens
@@ -216,7 +260,8 @@ func dial(network string, addr string, pass string) (redis.Conn, error) {
 	return c, err
 }
 
-// Connect connects to the redis, called only once
+// Connect connects to the redis, called only once, until a PingPong fails,
+// the Connected is not set here, the connection is not checked before the PingPong
 func (r *Service) Connect() {
 	c := r.Config
 
@@ -259,7 +304,6 @@ func (r *Service) Connect() {
 			return dial(c.Network, c.Addr, c.Password)
 		}
 	}
-	r.Connected = true
 	r.pool = pool
 }
 
@@ -43,6 +43,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"text/template"
 	"time"
 
@@ -279,25 +280,49 @@ func (s *SSH) bindTo(q *Q) {
 					}*/
 
 			}},
+			Command{Name: "routes", Description: "Prints the routes which the HTTP Server serves, the method, host, subdomain, path, name and the handlers.", Action: func(conn ssh.Channel) {
+				w := tabwriter.NewWriter(conn, 0, 8, 2, ' ', 0)
+				fmt.Fprintln(w, "METHOD\tHOST\tSUBDOMAIN\tPATH\tNAME\tHANDLERS")
+				for _, info := range q.Request.GetEntriesInfo() {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", info.Method, info.Host, info.Subdomain, info.Path, info.Name, strings.Join(info.Handlers, ", "))
+				}
+				w.Flush()
+			}},
 			Command{Name: "log", Description: "Adds a logger to the HTTP Server, waits for requests and prints them here.", Action: func(conn ssh.Channel) {
 				// the ssh user can still write commands, this is not blocking anything.
-				loggerMiddleware := Handlers{NewLoggerHandler(conn, true)}
-				for _, r := range q.Request.mux.lookups {
-					r.handlers = append(loggerMiddleware, r.handlers...)
+				if q.Request.mux == nil {
+					conn.Write([]byte(errMuxUnavailable.Error()))
+					return
+				}
+				loggerMiddleware := NewLoggerHandler(conn, true)
+				// replace the routes with copies which have the logger middleware in front, the new routes are served immediately, without downtime
+				err := q.Request.mux.update(func(lookups []*route) ([]*route, error) {
+					for i, r := range lookups {
+						newRoute := *r
+						newRoute.handlers = append(Handlers{loggerMiddleware}, r.handlers...)
+						lookups[i] = &newRoute
+					}
+					return lookups, nil
+				})
+				if err != nil {
+					conn.Write([]byte(err.Error()))
+					return
 				}
 
 				// register to the errors also
 				errorLoggerHandler := NewLoggerHandler(conn, false)
-				for k, v := range q.Request.Errors {
-					errorH := v
-					// wrap the error handler with the ssh logger middleware
-					q.Request.Errors[k] = func(ctx *Context) {
-						errorH(ctx)
-						errorLoggerHandler(ctx) // after the error handler because that is setting the status code.
+				// don't touch the map which is in use by the running server, publish a copy instead, like the routes
+				q.Request.updateErrors(func(errorHandlers Errors) Errors {
+					for k, v := range errorHandlers {
+						errorH := v
+						// wrap the error handler with the ssh logger middleware
+						errorHandlers[k] = func(ctx *Context) {
+							errorH(ctx)
+							errorLoggerHandler(ctx) // after the error handler because that is setting the status code.
+						}
 					}
-				}
-
-				q.Request.mux.build() // rebuilds the mux, this will give some milleseconds of downtime
+					return errorHandlers
+				})
 
 				loggerStartedMsg(conn)
 				// the middleware will still to run, we could remove it on exit but exit is general command I dont want to touch that
@@ -311,11 +336,6 @@ func (s *SSH) bindTo(q *Q) {
 			}
 		}
 
-		if !q.DisableServer {
-			go func() {
-				q.must(s.Listen())
-			}()
-		}
 	}
 }
 
@@ -0,0 +1,223 @@
+package q
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kataras/q/errors"
+)
+
+const (
+	// contentEventStream header value for Server-Sent Events.
+	contentEventStream = "text/event-stream"
+	// lastEventIDHeader represents the header "Last-Event-ID", sent by the client when it reconnects to an event stream
+	lastEventIDHeader = "Last-Event-ID"
+	// cacheControlHeader represents the header "Cache-Control"
+	cacheControlHeader = "Cache-Control"
+)
+
+var errSSEClosed = errors.New("Unable to send the event, the client has been disconnected")
+
+// clientGone returns a channel which is closed when the client disconnects or the request's context is done,
+// its goroutine exits when the stop is closed, the caller closes it when the stream ends.
+// The http.CloseNotifier is used only if the request has no cancelable context
+func (ctx *Context) clientGone(stop <-chan struct{}) <-chan struct{} {
+	gone := make(chan struct{})
+	done := ctx.Request.Context().Done()
+	var closeNotify <-chan bool
+	if done == nil {
+		closeNotify = ctx.ResponseWriter.CloseNotify()
+	}
+	go func() {
+		select {
+		case <-closeNotify:
+		case <-done:
+		case <-stop:
+			return
+		}
+		close(gone)
+	}()
+	return gone
+}
+
+// streamEncoder returns the negotiated Encoder and its compressor of a streamed response, if the Q's Gzip is enabled, the client accepts it
+// and the Content-Type is one of the Compression.ContentTypes, nil otherwise
+func (ctx *Context) streamEncoder() (Encoder, EncodeWriter) {
+	if !ctx.q.Gzip {
+		return nil, nil
+	}
+	return ctx.acquireEncoder(ctx.ResponseWriter, ctx.ResponseWriter.Header().Get(contentType), -1)
+}
+
+// StreamWriter streams the response, the writer is called again and again, and its writes are flushed to the client after each call,
+// until it returns false or the client disconnects.
+// The response is compressed if the Q's Gzip is enabled, the client accepts one of the Compression.Encoders and the Content-Type, setted before, is one of the Compression.ContentTypes.
+//
+// Example:
+//  ctx.StreamWriter(func(w io.Writer) bool {
+//  	fmt.Fprintf(w, "tick %s\n", <-ticker.C)
+//  	return true // keep the connection open
+//  })
+func (ctx *Context) StreamWriter(writer func(w io.Writer) bool) {
+	var out io.Writer = ctx.ResponseWriter
+	encoder, encodeWriter := ctx.streamEncoder()
+	if encoder != nil {
+		defer encoder.Release(encodeWriter)
+		out = encodeWriter
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	gone := ctx.clientGone(stop)
+	for {
+		select {
+		case <-gone:
+			return
+		default:
+		}
+		keepOpen := writer(out)
+		if encodeWriter != nil {
+			encodeWriter.Flush()
+		}
+		ctx.ResponseWriter.Flush()
+		if !keepOpen {
+			return
+		}
+	}
+}
+
+// SSE is a Server-Sent Events stream, a lighter alternative to the websockets for one-way feeds, look ctx.SSE
+type SSE struct {
+	ctx          *Context
+	out          io.Writer
+	encoder      Encoder
+	encodeWriter EncodeWriter
+	gone         <-chan struct{}
+	stop         chan struct{}
+}
+
+// SSE returns the Server-Sent Events stream of the request, the first call sends the 'text/event-stream' headers to the client.
+// The events are flushed immediately, they are compressed if the Q's Gzip is enabled and the client accepts one of the Compression.Encoders.
+//
+// Example:
+//  sse := ctx.SSE()
+//  sse.Retry(3 * time.Second)
+//  for {
+//  	select {
+//  	case <-sse.Done():
+//  		return
+//  	case msg := <-messages:
+//  		sse.Send("message", msg.ID, msg)
+//  	}
+//  }
+func (ctx *Context) SSE() *SSE {
+	if ctx.sse != nil {
+		return ctx.sse
+	}
+	h := ctx.ResponseWriter.Header()
+	// the event streams are always UTF-8 encoded
+	h.Set(contentType, contentEventStream+"; charset=UTF-8")
+	h.Set(cacheControlHeader, "no-cache")
+	h.Set("Connection", "keep-alive")
+	// disable the buffering of the reverse proxies, i.e nginx
+	h.Set("X-Accel-Buffering", "no")
+
+	s := &SSE{ctx: ctx, out: ctx.ResponseWriter, stop: make(chan struct{})}
+	s.gone = ctx.clientGone(s.stop)
+	if s.encoder, s.encodeWriter = ctx.streamEncoder(); s.encoder != nil {
+		s.out = s.encodeWriter
+	}
+	ctx.SetStatusCode(StatusOK)
+	ctx.ResponseWriter.Flush()
+	ctx.sse = s
+	return s
+}
+
+// LastEventID returns the id of the last event which the client received before it reconnected, the 'Last-Event-ID' header, empty if it's a new client
+func (s *SSE) LastEventID() string {
+	return s.ctx.RequestHeader(lastEventIDHeader)
+}
+
+// Done returns a channel which is closed when the client disconnects
+func (s *SSE) Done() <-chan struct{} {
+	return s.gone
+}
+
+// Send sends an event to the client, the event's name and id are optional,
+// the data can be a string, a []byte or any other value which is sent as JSON.
+// Returns an error if the client has been disconnected
+func (s *SSE) Send(event string, id string, data interface{}) error {
+	var payload []byte
+	switch v := data.(type) {
+	case string:
+		payload = []byte(v)
+	case []byte:
+		payload = v
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		payload = b
+	}
+
+	var buf bytes.Buffer
+	if id = sseField(id); id != "" {
+		buf.WriteString("id: " + id + "\n")
+	}
+	if event = sseField(event); event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	lines := strings.Split(strings.Replace(string(payload), "\r\n", "\n", -1), "\n")
+	for _, line := range lines {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteByte('\n')
+	return s.write(buf.Bytes())
+}
+
+// Retry tells the client how long to wait before it reconnects, if the connection is lost
+func (s *SSE) Retry(d time.Duration) error {
+	return s.write([]byte("retry: " + strconv.FormatInt(int64(d/time.Millisecond), 10) + "\n\n"))
+}
+
+// Comment sends a comment, it's ignored by the client, useful to keep the connection alive
+func (s *SSE) Comment(text string) error {
+	return s.write([]byte(": " + sseField(text) + "\n\n"))
+}
+
+func (s *SSE) write(b []byte) error {
+	select {
+	case <-s.gone:
+		return errSSEClosed.Return()
+	default:
+	}
+	if _, err := s.out.Write(b); err != nil {
+		return err
+	}
+	if s.encodeWriter != nil {
+		if err := s.encodeWriter.Flush(); err != nil {
+			return err
+		}
+	}
+	s.ctx.ResponseWriter.Flush()
+	return nil
+}
+
+// close releases the compressor, if any, and stops the watch of the client, it's called at the end of the request
+func (s *SSE) close() {
+	close(s.stop)
+	if s.encoder != nil {
+		s.encoder.Release(s.encodeWriter)
+		s.encoder, s.encodeWriter = nil, nil
+	}
+}
+
+// sseField removes the new lines of an event's field, they are not allowed
+func sseField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
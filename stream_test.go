@@ -0,0 +1,136 @@
+package q
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamAndSSE(t *testing.T) {
+	qq := (&Q{Host: "mydomain.com:80", DisableServer: true, Gzip: true, Request: Request{Entries: Entries{
+		Entry{Method: "GET", Path: "/stream", Handler: func(ctx *Context) {
+			n := 0
+			ctx.SetContentType("text/plain")
+			ctx.StreamWriter(func(w io.Writer) bool {
+				n++
+				fmt.Fprintf(w, "chunk%d\n", n)
+				return n < 3
+			})
+		}},
+		Entry{Method: "GET", Path: "/sse", Handler: func(ctx *Context) {
+			sse := ctx.SSE()
+			sse.Retry(2 * time.Second)
+			sse.Send("greet", ctx.SSE().LastEventID()+"1", "a\nb")
+			sse.Send("", "", map[string]int{"x": 1})
+			for {
+				select {
+				case <-sse.Done():
+					if err := sse.Send("x", "", "y"); err == nil {
+						t.Error("expected closed")
+					}
+					return
+				case <-time.After(10 * time.Millisecond):
+					sse.Comment("ping")
+				}
+			}
+		}},
+	}}}).Go()
+	srv := httptest.NewServer(qq)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/stream", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(gz)
+	res.Body.Close()
+	if err != nil || string(b) != "chunk1\nchunk2\nchunk3\n" {
+		t.Errorf("%q %v", b, err)
+	}
+
+	req, _ = http.NewRequest("GET", srv.URL+"/sse", nil)
+	req.Header.Set("Last-Event-ID", "4")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+		t.Errorf("ctype %v", res.Header)
+	}
+	r := bufio.NewReader(res.Body)
+	var lines []string
+	for len(lines) < 9 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	got := strings.Join(lines, "")
+	want := "retry: 2000\n\nid: 41\nevent: greet\ndata: a\ndata: b\n\ndata: {\"x\":1}\n\n"
+	if got != want {
+		t.Errorf("%q", got)
+	}
+	// the srv.Close waits for the handler, which returns when the client is gone
+	res.Body.Close()
+}
+
+func TestStreamClientGone(t *testing.T) {
+	qq := newQ(Entries{
+		Entry{Method: "GET", Path: "/stream", Handler: func(ctx *Context) {
+			n := 0
+			ctx.StreamWriter(func(w io.Writer) bool {
+				n++
+				return n < 3
+			})
+		}},
+		Entry{Method: "GET", Path: "/sse", Handler: func(ctx *Context) {
+			ctx.SSE().Comment("ping")
+		}},
+	})
+
+	// the watchers of the finished streams must exit
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		serve(qq, "GET", "/stream")
+		serve(qq, "GET", "/sse")
+	}
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Fatalf("goroutines leaked: %d, before %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the stream ends when the request's context is canceled
+	c, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/sse", nil).WithContext(c)
+	var closed bool
+	qq = newQ(Entries{Entry{Method: "GET", Path: "/sse", Handler: func(ctx *Context) {
+		select {
+		case <-ctx.SSE().Done():
+			closed = true
+		case <-time.After(time.Second):
+		}
+	}}})
+	qq.ServeHTTP(httptest.NewRecorder(), req)
+	if !closed {
+		t.Error("the SSE.Done is not closed when the request's context is canceled")
+	}
+}
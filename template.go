@@ -25,7 +25,7 @@ type (
 	Templates []Template
 )
 
-func (templates Templates) loadTo(t *templateEngines) {
+func (templates Templates) loadTo(t *templateEngines) error {
 	if len(templates) == 0 {
 		// set the default, which is the standard html engine
 		t.add(html.New())
@@ -36,9 +36,8 @@ func (templates Templates) loadTo(t *templateEngines) {
 			}
 		}
 	}
-	if err := t.loadAll(); err != nil {
-		panic(err) // panic on templates loading before listening if we have an error.
-	}
+	// the error is returned by the Q.Build, before listening
+	return t.loadAll()
 }
 
 // TemplateLayout (almost all q's template engines support this) is a Entry Parser, useful when you have group of routes sharing the same template view layout
@@ -253,15 +252,12 @@ func (t *templateEngineWrapper) execute(ctx *Context, filename string, binding i
 
 	ctx.SetContentType(contentHTML + "; charset=" + charset)
 
-	var out io.Writer
-	if gzipEnabled && ctx.clientAllowsGzip() {
-		ctx.ResponseWriter.Header().Add(varyHeader, acceptEncodingHeader)
-		ctx.SetHeader(contentEncodingHeader, "gzip")
-		gzipWriter := AcquireGzip(ctx.ResponseWriter)
-		defer ReleaseGzip(gzipWriter)
-		out = gzipWriter
-	} else {
-		out = ctx.ResponseWriter
+	var out io.Writer = ctx.ResponseWriter
+	if gzipEnabled {
+		if encoder, w := ctx.acquireEncoder(ctx.ResponseWriter, contentHTML, -1); encoder != nil {
+			defer encoder.Release(w)
+			out = w
+		}
 	}
 
 	err = t.ExecuteWriter(out, filename, binding, options...)
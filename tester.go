@@ -43,7 +43,9 @@ func NewTester(q *Q, t *testing.T) *httpexpect.Expect {
 
 	// edw prosoxh giati borei to Handler na mhn exei ginei set akoma, ara to tester prepei na kaleite mono sto q.Test() oxi apo mono tou
 	if q.Request.Handler == nil {
-		q.Request.build(host)
+		if err := q.Request.build(host); err != nil {
+			t.Fatal(err)
+		}
 	}
 
 	testConfiguration := httpexpect.Config{
@@ -0,0 +1,99 @@
+package q
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	ctxErr := make(chan error, 1)
+	qq := newQ(Entries{
+		Entry{Path: "/slow", Timeout: 20 * time.Millisecond, Entries: Entries{
+			Entry{Method: "GET", Path: "/wait", Handler: func(ctx *Context) {
+				select {
+				case <-ctx.Done():
+					ctxErr <- ctx.Err()
+				case <-time.After(time.Second):
+				}
+				io.WriteString(ctx.ResponseWriter, "late")
+			}},
+			Entry{Method: "GET", Path: "/hang", TimeoutStatus: 504, Handler: func(ctx *Context) {
+				time.Sleep(100 * time.Millisecond)
+			}},
+			Entry{Method: "GET", Path: "/fast", Handler: func(ctx *Context) {
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("no deadline")
+				}
+				ctx.Set("k", "v")
+				ctx.SetHeader("X-A", "1")
+				ctx.ResponseWriter.WriteHeader(201)
+				io.WriteString(ctx.ResponseWriter, "fast")
+			}},
+		}},
+		Entry{Method: "GET", Path: "/plain", Handler: func(ctx *Context) {
+			if ctx.Value(ctxKey{}) != "std" {
+				t.Error("value")
+			}
+		}},
+	})
+	rec := serve(qq, "GET", "/slow/wait")
+	if err := <-ctxErr; rec.Code != 503 || err != context.DeadlineExceeded {
+		t.Errorf("wait %d %q %v", rec.Code, rec.Body.String(), err)
+	}
+	rec = serve(qq, "GET", "/slow/hang")
+	if rec.Code != 504 {
+		t.Errorf("hang %d", rec.Code)
+	}
+	rec = serve(qq, "GET", "/slow/fast")
+	if rec.Code != 201 || rec.Body.String() != "fast" || rec.Header().Get("X-A") != "1" {
+		t.Errorf("fast %d %q", rec.Code, rec.Body.String())
+	}
+	req := httptest.NewRequest("GET", "/plain", nil)
+	req = req.WithContext(context.WithValue(req.Context(), ctxKey{}, "std"))
+	qq.ServeHTTP(httptest.NewRecorder(), req)
+	time.Sleep(150 * time.Millisecond)
+}
+
+type ctxKey struct{}
+
+func TestTimeoutLateWrite(t *testing.T) {
+	late := make(chan struct{})
+	finished := make(chan string, 1)
+	qq := newQ(Entries{
+		Entry{Method: "GET", Path: "/slow", Timeout: 20 * time.Millisecond, Handler: func(ctx *Context) {
+			ctx.ResponseWriter.Buffer()
+			<-late
+			io.WriteString(ctx.ResponseWriter, "late")
+		}, Done: Handlers{func(ctx *Context) {
+			// runs with the copy of the timed out context, after the response has been sent
+			ctx.SetHeader("X-Done", "1")
+			io.WriteString(ctx.ResponseWriter, "done")
+			finished <- string(ctx.ResponseWriter.Body())
+		}}},
+		Entry{Method: "GET", Path: "/next", Handler: func(ctx *Context) {
+			ctx.ResponseWriter.Buffer()
+			io.WriteString(ctx.ResponseWriter, "next")
+			// the late write of the timed out request runs while this request is buffered
+			close(late)
+			if body := <-finished; body != "latedone" {
+				t.Errorf("done body %q", body)
+			}
+			if body := string(ctx.ResponseWriter.Body()); body != "next" {
+				t.Errorf("the buffered body is changed by the timed out request: %q", body)
+			}
+		}},
+	})
+	// doesn't set the status code, the timeout does
+	qq.Request.Errors[503] = func(ctx *Context) { io.WriteString(ctx.ResponseWriter, "busy") }
+
+	rec := serve(qq, "GET", "/slow")
+	if rec.Code != 503 || rec.Body.String() != "busy" || rec.Header().Get("X-Done") != "" {
+		t.Fatalf("got %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	if rec := serve(qq, "GET", "/next"); rec.Body.String() != "next" || rec.Header().Get("X-Done") != "" {
+		t.Fatalf("got %q %v", rec.Body.String(), rec.Header())
+	}
+}
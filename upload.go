@@ -0,0 +1,279 @@
+package q
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kataras/q/errors"
+)
+
+const (
+	// DefaultMultipartMemory is the maximum bytes of a multipart form which are kept in memory by the ctx.FormFile, the rest are stored to temp files, 32MB
+	DefaultMultipartMemory int64 = 32 << 20
+	// sniffLen is the number of bytes which are used to detect the content type of an uploaded file
+	sniffLen = 512
+)
+
+var (
+	errUploadNotMultipart = errors.New("Unable to read the uploads, the request is not a multipart form. Trace: %s")
+	errUploadFileTooLarge = errors.New("The uploaded file: '%s' is larger than %d bytes")
+	errUploadContentType  = errors.New("The content type: '%s' of the uploaded file: '%s' is not allowed")
+)
+
+// FormFile returns the first file of the multipart form's key, the form is parsed if not parsed before,
+// its size is limited by the Request.MaxBodySize, the files which don't fit to the DefaultMultipartMemory are stored to temp files
+// which are removed at the end of the request
+func (ctx *Context) FormFile(key string) (multipart.File, *multipart.FileHeader, error) {
+	r := ctx.Request
+	if r.MultipartForm == nil {
+		maxSize := ctx.maxBodySize()
+		body := ctx.limitBody(maxSize)
+		if err := r.ParseMultipartForm(DefaultMultipartMemory); err != nil {
+			if body.exceeded {
+				return nil, nil, BodyTooLargeError{MaxSize: maxSize}
+			}
+			return nil, nil, err
+		}
+	}
+	return r.FormFile(key)
+}
+
+// SaveUploadedFile saves the uploaded file, i.e from the ctx.FormFile, to the dst path, the dst's directories are created if not exist,
+// returns the written bytes
+func (ctx *Context) SaveUploadedFile(file *multipart.FileHeader, dst string) (int64, error) {
+	src, err := file.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	return saveTo(src, dst)
+}
+
+// saveTo copies the src to a new file, the dst
+func saveTo(src io.Reader, dst string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, src)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return n, err
+}
+
+// maxBodySize returns the Request.MaxBodySize or the DefaultMaxBodySize
+func (ctx *Context) maxBodySize() int64 {
+	if maxSize := ctx.q.Request.MaxBodySize; maxSize > 0 {
+		return maxSize
+	}
+	return DefaultMaxBodySize
+}
+
+// UploadOptions are the limits of the ctx.Uploads
+type UploadOptions struct {
+	// MaxFileSize is the maximum bytes of each file, 0 for no limit except the MaxTotalSize
+	MaxFileSize int64
+	// MaxTotalSize is the maximum bytes of the whole request body, defaults to the Request.MaxBodySize
+	MaxTotalSize int64
+	// AllowedTypes are the allowed content types of the files, detected from their contents, not the ones which the client sent,
+	// i.e 'image/png' or 'image/*', empty for any content type
+	AllowedTypes []string
+	// TempDir is the directory of the Upload.SaveTemp's files, defaults to the os.TempDir()
+	TempDir string
+}
+
+// Uploads is a streaming iterator of the files of a multipart form, the files are not kept in memory or to the disk,
+// each file should be read or saved before the next one, look ctx.Uploads
+type Uploads struct {
+	// Values are the non-file values of the form which are read before the current file
+	Values  url.Values
+	ctx     *Context
+	reader  *multipart.Reader
+	options UploadOptions
+}
+
+// Upload is a file of the Uploads, it's an io.Reader of the file's contents
+type Upload struct {
+	// FieldName is the form's key of the file
+	FieldName string
+	// FileName is the name of the file, without its directories
+	FileName string
+	// ContentType is the content type which is detected from the file's contents
+	ContentType string
+	// Header is the header of the file's part
+	Header textproto.MIMEHeader
+	reader io.Reader
+	ctx    *Context
+	dir    string
+}
+
+// Uploads returns the streaming iterator of the request's multipart form files
+//
+// Example:
+//  uploads, err := ctx.Uploads(q.UploadOptions{MaxFileSize: 10 << 20, AllowedTypes: []string{"image/*"}})
+//  for {
+//  	file, err := uploads.Next()
+//  	if err == io.EOF {
+//  		break
+//  	}
+//  	if err != nil {
+//  		// the file is too large, its content type is not allowed or the body couldn't be read
+//  	}
+//  	file.Save("./media/" + file.FileName)
+//  }
+func (ctx *Context) Uploads(options UploadOptions) (*Uploads, error) {
+	if options.MaxTotalSize <= 0 {
+		options.MaxTotalSize = ctx.maxBodySize()
+	}
+	r := ctx.Request
+	ctx.limitBody(options.MaxTotalSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, errUploadNotMultipart.Format(err.Error())
+	}
+	return &Uploads{Values: url.Values{}, ctx: ctx, reader: reader, options: options}, nil
+}
+
+// Next returns the next file, io.EOF if there are no more files.
+// Returns an error if the file's content type is not allowed, the Next can be called again to skip that file.
+func (u *Uploads) Next() (*Upload, error) {
+	for {
+		part, err := u.reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FileName() == "" {
+			// a form value, keep it
+			value, err := ioutil.ReadAll(part)
+			if err != nil {
+				return nil, err
+			}
+			u.Values.Add(part.FormName(), string(value))
+			continue
+		}
+
+		fileName := filepath.Base(filepath.Clean("/" + strings.Replace(part.FileName(), "\\", "/", -1)))
+		sniff := make([]byte, sniffLen)
+		n, err := io.ReadFull(part, sniff)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		sniff = sniff[0:n]
+		ctype := http.DetectContentType(sniff)
+		if !isAllowedContentType(ctype, u.options.AllowedTypes) {
+			return nil, errUploadContentType.Format(ctype, fileName)
+		}
+
+		var reader io.Reader = io.MultiReader(bytes.NewReader(sniff), part)
+		if u.options.MaxFileSize > 0 {
+			reader = &limitedUpload{reader: reader, remaining: u.options.MaxFileSize, max: u.options.MaxFileSize, fileName: fileName}
+		}
+		return &Upload{
+			FieldName:   part.FormName(),
+			FileName:    fileName,
+			ContentType: ctype,
+			Header:      part.Header,
+			reader:      reader,
+			ctx:         u.ctx,
+			dir:         u.options.TempDir,
+		}, nil
+	}
+}
+
+// isAllowedContentType returns true if the allowed is empty or one of them matches with the content type, 'type/*' matches with any subtype
+func isAllowedContentType(ctype string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	if mediaType, _, err := mime.ParseMediaType(ctype); err == nil {
+		ctype = mediaType
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == ctype || a == "*/*" || (strings.HasSuffix(a, "/*") && strings.HasPrefix(ctype, a[0:len(a)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read reads the file's contents, it returns an error if the file is larger than the UploadOptions.MaxFileSize
+func (f *Upload) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+// Save saves the file to the dst path, the dst's directories are created if not exist, returns the written bytes,
+// the dst is removed if the file couldn't be saved, i.e it's too large
+func (f *Upload) Save(dst string) (int64, error) {
+	return saveTo(f, dst)
+}
+
+// SaveTemp saves the file to a new temp file of the UploadOptions.TempDir and returns its path,
+// the temp file is removed at the end of the request, move it to keep it
+func (f *Upload) SaveTemp() (string, error) {
+	tmp, err := ioutil.TempFile(f.dir, "q-upload-")
+	if err != nil {
+		return "", err
+	}
+	f.ctx.tempFiles = append(f.ctx.tempFiles, tmp.Name())
+	_, err = io.Copy(tmp, f)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// limitedUpload returns an error if the file is larger than the max bytes
+type limitedUpload struct {
+	reader    io.Reader
+	remaining int64
+	max       int64
+	fileName  string
+}
+
+func (l *limitedUpload) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errUploadFileTooLarge.Format(l.fileName, l.max)
+	}
+	if int64(len(p)) > l.remaining+1 {
+		// read one more byte to know if the file is larger than the max
+		p = p[0 : l.remaining+1]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n + int(l.remaining), errUploadFileTooLarge.Format(l.fileName, l.max)
+	}
+	return n, err
+}
+
+// removeUploads removes the temp files of the request's uploads, it's called at the end of the request
+func (ctx *Context) removeUploads() {
+	if ctx.Request.MultipartForm != nil {
+		ctx.Request.MultipartForm.RemoveAll()
+	}
+	for _, name := range ctx.tempFiles {
+		os.Remove(name)
+	}
+	ctx.tempFiles = ctx.tempFiles[0:0]
+}
@@ -0,0 +1,98 @@
+package q
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func multipartBody(files map[string]string) (*bytes.Buffer, string) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	w.WriteField("title", "hello")
+	for name, content := range files {
+		fw, _ := w.CreateFormFile("file", name)
+		io.WriteString(fw, content)
+	}
+	w.Close()
+	return &buf, w.FormDataContentType()
+}
+
+func TestUploads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "q-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("x", 100)
+	var tempPath string
+	var results []string
+	qq := newQ(Entries{
+		Entry{Method: "POST", Path: "/form", Handler: func(ctx *Context) {
+			_, fh, err := ctx.FormFile("file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ctx.SaveUploadedFile(fh, filepath.Join(dir, "a", fh.Filename)); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		Entry{Method: "POST", Path: "/stream", Handler: func(ctx *Context) {
+			ups, err := ctx.Uploads(UploadOptions{MaxFileSize: 50, AllowedTypes: []string{"image/*"}, TempDir: dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for {
+				f, err := ups.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					results = append(results, "err:"+err.Error())
+					continue
+				}
+				p, err := f.SaveTemp()
+				if err != nil {
+					results = append(results, f.FileName+":"+err.Error())
+					continue
+				}
+				tempPath = p
+				results = append(results, f.FileName+":"+f.ContentType+":"+ups.Values.Get("title"))
+			}
+		}},
+	})
+	body, ctype := multipartBody(map[string]string{"../../etc/x.txt": "plain text"})
+	req := httptest.NewRequest("POST", "/form", body)
+	req.Header.Set("Content-Type", ctype)
+	qq.ServeHTTP(httptest.NewRecorder(), req)
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "a", "x.txt")); err != nil || string(b) != "plain text" {
+		t.Errorf("%q %v", b, err)
+	}
+
+	for _, c := range []struct{ name, content, want string }{
+		{"small.png", "\x89PNG\r\n\x1a\nabc", "small.png:image/png:hello"},
+		{"big.png", png, "big.png:The uploaded file: 'big.png' is larger than 50 bytes"},
+		{"a.txt", "hello world", "err:The content type: 'text/plain; charset=utf-8' of the uploaded file: 'a.txt' is not allowed"},
+	} {
+		results = nil
+		body, ctype = multipartBody(map[string]string{c.name: c.content})
+		req = httptest.NewRequest("POST", "/stream", body)
+		req.Header.Set("Content-Type", ctype)
+		qq.ServeHTTP(httptest.NewRecorder(), req)
+		if len(results) != 1 || strings.Replace(results[0], "\nError: ", "", 1) != c.want {
+			t.Errorf("%s: %q", c.name, results)
+		}
+	}
+	if tempPath == "" {
+		t.Fatal("no temp")
+	}
+	if _, err := os.Stat(tempPath); !os.IsNotExist(err) {
+		t.Errorf("temp not removed %v", err)
+	}
+}
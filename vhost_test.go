@@ -0,0 +1,133 @@
+package q
+
+import (
+	"io"
+	"testing"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	qq := newQ(Entries{
+		{Method: "GET", Path: "/", Handler: writeS("main")},
+		{Path: "example.org/", Begin: Handlers{func(ctx *Context) { ctx.SetHeader("X-Host", "ex") }},
+			Errors: Errors{404: func(ctx *Context) { ctx.SetStatusCode(404); io.WriteString(ctx.ResponseWriter, "ex404") }},
+			Entries: Entries{
+				{Method: "GET", Path: "/", Name: "exhome", Handler: writeS("exhome")},
+				{Method: "GET", Path: "/users/:id", Name: "exuser", Handler: echoParams},
+				{Path: "api.", Entries: Entries{{Method: "GET", Path: "/v1", Name: "exapi", Handler: writeS("exapi")}}},
+			}},
+		{Path: "*.wild.com/", Entries: Entries{{Method: "GET", Path: "/", Name: "wild", Handler: writeS("wild")}}},
+		{Method: "GET", Path: "other.net:9000/x", Name: "other", Handler: writeS("other")},
+		{Path: "sub.", Entries: Entries{{Path: "/p", Entries: Entries{{Method: "GET", Path: "/q", Name: "subq", Handler: writeS("subq")}}}}},
+	})
+	cases := []struct {
+		host, path, body string
+		code             int
+	}{
+		{"mydomain.com", "/", "main", 200},
+		{"example.org", "/", "exhome", 200},
+		{"EXAMPLE.org:8080", "/users/3", "id=3", 200},
+		{"example.org", "/nope", "ex404", 404},
+		{"api.example.org", "/v1", "exapi", 200},
+		{"a.b.wild.com", "/", "wild", 200},
+		{"wild.com", "/", "main", 200},
+		{"other.net:9000", "/x", "other", 200},
+		{"other.net", "/x", "", 404},
+		{"sub.mydomain.com", "/p/q", "subq", 200},
+	}
+	for _, c := range cases {
+		rec := serve(qq, "GET", c.path, "Host", c.host)
+		if rec.Code != c.code || (c.body != "" && rec.Body.String() != c.body) {
+			t.Errorf("%s%s: %d %q", c.host, c.path, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := serve(qq, "GET", "/", "Host", "example.org"); rec.Header().Get("X-Host") != "ex" {
+		t.Error("begin not run")
+	}
+	for _, c := range []struct {
+		name string
+		args []interface{}
+		want string
+	}{
+		{"exuser", []interface{}{5}, "http://example.org/users/5"},
+		{"exapi", nil, "http://api.example.org/v1"},
+		{"other", nil, "http://other.net:9000/x"},
+		{"subq", nil, "http://sub.mydomain.com:80/p/q"},
+		{"wild", []interface{}{"x"}, "http://x.wild.com/"},
+	} {
+		if got := qq.URL(c.name, c.args...); got != c.want {
+			t.Errorf("%s: %s", c.name, got)
+		}
+	}
+	if e, ok := qq.Request.GetEntry("exapi").(HostEntry); !ok || e.Host() != "api.example.org" {
+		t.Errorf("host entry %#v", qq.Request.GetEntry("exapi"))
+	}
+}
+
+func TestSubdomains(t *testing.T) {
+	h := func(ctx *Context) { io.WriteString(ctx.ResponseWriter, ctx.Subdomain()+"|"+ctx.Subdomains()) }
+	qq := newQ(Entries{{Method: "GET", Path: "*./", Handler: h}, {Method: "GET", Path: "example.org/", Handler: h}})
+	for host, want := range map[string]string{"eu.tenant.mydomain.com": "eu|eu.tenant", "api.mydomain.com": "api|api", "example.org": "example|example"} {
+		if rec := serve(qq, "GET", "/", "Host", host); rec.Body.String() != want {
+			t.Errorf("%s: %q", host, rec.Body.String())
+		}
+	}
+}
+
+func TestSubdomainParams(t *testing.T) {
+	qq := newQ(Entries{
+		{Method: "GET", Path: "/", Handler: writeS("main")},
+		{Path: "api.", Entries: Entries{{Method: "GET", Path: "/", Handler: writeS("api")}}},
+		{Path: ":tenant.", Entries: Entries{{Method: "GET", Path: "/users/:id", Name: "tuser", Handler: echoParams}}},
+		{Path: ":region.", Entries: Entries{{Path: ":tenant.", Entries: Entries{{Method: "GET", Path: "/", Name: "nested", Handler: echoParams}}}}},
+		{Path: "admin.:tenant.", Entries: Entries{{Method: "GET", Path: "/", Handler: func(ctx *Context) { io.WriteString(ctx.ResponseWriter, ctx.Param("tenant")+"|"+ctx.Subdomains()) }}}},
+		{Path: "*.", Entries: Entries{{Method: "GET", Path: "/w", Name: "w", Handler: func(ctx *Context) { io.WriteString(ctx.ResponseWriter, ctx.Subdomains()) }}}},
+	})
+	cases := []struct {
+		host, path, body string
+		code             int
+	}{
+		{"mydomain.com", "/", "main", 200},
+		{"api.mydomain.com", "/", "api", 200},
+		{"acme.mydomain.com", "/users/4", "tenant=acme,id=4", 200},
+		{"acme.eu.mydomain.com", "/", "tenant=acme,region=eu", 200},
+		{"admin.acme.mydomain.com", "/", "acme|admin.acme", 200},
+		{"a.b.c.mydomain.com", "/w", "a.b.c", 200},
+		{"mydomain.com", "/w", "", 404},
+	}
+	for _, c := range cases {
+		rec := serve(qq, "GET", c.path, "Host", c.host)
+		if rec.Code != c.code || (c.body != "" && rec.Body.String() != c.body) {
+			t.Errorf("%s%s: %d %q", c.host, c.path, rec.Code, rec.Body.String())
+		}
+	}
+	if got := qq.URL("tuser", "acme", 4); got != "http://acme.mydomain.com:80/users/4" {
+		t.Error(got)
+	}
+	if got := qq.URL("tuser", Map{"tenant": "acme"}, 4); got != "http://acme.mydomain.com:80/users/4" {
+		t.Error(got)
+	}
+	if got := qq.URL("nested", Map{"tenant": "acme", "region": "eu"}); got != "http://acme.eu.mydomain.com:80/" {
+		t.Error(got)
+	}
+	if got := qq.URL("nested", Map{"tenant": "acme"}); got != "" {
+		t.Error(got)
+	}
+	if got := qq.URL("w", "x"); got != "http://x.mydomain.com:80/w" {
+		t.Error(got)
+	}
+}
+
+func TestSubdomainWithPath(t *testing.T) {
+	qq := newQ(Entries{
+		{Method: "GET", Path: "admin.:tenant./x", Handler: echoParams},
+		{Method: "GET", Path: "api./", Handler: writeS("api")},
+	})
+	for _, c := range []struct{ host, path, body string }{
+		{"admin.acme.mydomain.com", "/x", "tenant=acme"},
+		{"api.mydomain.com", "/", "api"},
+	} {
+		if rec := serve(qq, "GET", c.path, "Host", c.host); rec.Body.String() != c.body {
+			t.Errorf("%s%s: %d %q", c.host, c.path, rec.Code, rec.Body.String())
+		}
+	}
+}
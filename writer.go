@@ -0,0 +1,181 @@
+package q
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+
+	"github.com/kataras/q/errors"
+)
+
+var errHijackNotSupported = errors.New("Unable to hijack the connection, the underline http.ResponseWriter doesn't implement the http.Hijacker")
+
+// ResponseWriter is the http.ResponseWriter of the Context, it keeps the status code, the size of the written body and whether the headers are written.
+// It can buffer the response, after the .Buffer, so the Done middleware can inspect and rewrite it before it's sent to the client.
+type ResponseWriter interface {
+	http.ResponseWriter
+	http.Flusher
+	http.Hijacker
+	http.CloseNotifier
+	// StatusCode returns the status code of the response, 200 if not setted
+	StatusCode() int
+	// Size returns the bytes of the body, the written and the buffered
+	Size() int
+	// Written returns true if the status code and the headers are sent to the client
+	Written() bool
+	// Buffer keeps the status code and the body in memory until the .Flush or the end of the request, does nothing if the headers are written,
+	// while buffered the WriteHeader can change the status code
+	Buffer()
+	// Body returns the buffered body, nil if not buffered
+	Body() []byte
+	// SetBody replaces the buffered body, does nothing if not buffered
+	SetBody(b []byte)
+	// Underline returns the http.ResponseWriter which is wrapped
+	Underline() http.ResponseWriter
+}
+
+// responseWriter is the Context's ResponseWriter, it's part of the pooled Context
+type responseWriter struct {
+	underline  http.ResponseWriter
+	statusCode int
+	size       int
+	written    bool
+	hijacked   bool
+	buffered   bool
+	body       []byte
+}
+
+var _ ResponseWriter = &responseWriter{}
+
+func (w *responseWriter) reset(underline http.ResponseWriter) {
+	w.underline = underline
+	w.statusCode = 0
+	w.size = 0
+	w.written = false
+	w.hijacked = false
+	w.buffered = false
+	w.body = w.body[0:0]
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.underline.Header()
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.written || w.hijacked {
+		return
+	}
+	w.statusCode = statusCode
+	if !w.buffered {
+		w.writeHeader()
+	}
+}
+
+func (w *responseWriter) writeHeader() {
+	w.written = true
+	w.underline.WriteHeader(w.StatusCode())
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.buffered {
+		w.body = append(w.body, b...)
+		return len(b), nil
+	}
+	if !w.written && !w.hijacked {
+		w.writeHeader()
+	}
+	n, err := w.underline.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *responseWriter) StatusCode() int {
+	if w.statusCode == 0 {
+		return StatusOK
+	}
+	return w.statusCode
+}
+
+func (w *responseWriter) Size() int {
+	return w.size + len(w.body)
+}
+
+func (w *responseWriter) Written() bool {
+	return w.written
+}
+
+func (w *responseWriter) Buffer() {
+	if !w.written && !w.hijacked {
+		w.buffered = true
+	}
+}
+
+func (w *responseWriter) Body() []byte {
+	if !w.buffered {
+		return nil
+	}
+	return w.body
+}
+
+func (w *responseWriter) SetBody(b []byte) {
+	if w.buffered {
+		w.body = append(w.body[0:0], b...)
+	}
+}
+
+func (w *responseWriter) Underline() http.ResponseWriter {
+	return w.underline
+}
+
+// flushBuffer sends the buffered status code and body, if any, and stops the buffering
+func (w *responseWriter) flushBuffer() {
+	if !w.buffered {
+		return
+	}
+	w.buffered = false
+	if w.hijacked {
+		return
+	}
+	body := w.body
+	w.body = w.body[0:0]
+	if len(body) > 0 {
+		w.Write(body)
+	} else if !w.written {
+		w.writeHeader()
+	}
+}
+
+// Flush sends the buffered response, if any, and flushes the underline http.ResponseWriter, if it's an http.Flusher
+func (w *responseWriter) Flush() {
+	w.flushBuffer()
+	if flusher, ok := w.underline.(http.Flusher); ok {
+		if !w.written && !w.hijacked {
+			w.writeHeader()
+		}
+		flusher.Flush()
+	}
+}
+
+// Hijack takes over the connection, if the underline http.ResponseWriter is an http.Hijacker, the buffered response is discarded
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.underline.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackNotSupported.Return()
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		w.hijacked = true
+		w.buffered = false
+		w.body = w.body[0:0]
+	}
+	return conn, rw, err
+}
+
+// CloseNotify forwards to the underline http.CloseNotifier, the http.Server's response writer is one,
+// it returns a channel which never receives if the underline http.ResponseWriter is not an http.CloseNotifier, i.e the httptest.ResponseRecorder
+func (w *responseWriter) CloseNotify() <-chan bool {
+	if notifier, ok := w.underline.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
@@ -0,0 +1,90 @@
+package q
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseWriter(t *testing.T) {
+	var logs bytes.Buffer
+	var status, size int
+	var written bool
+	qq := newQ(Entries{
+		Entry{Method: "GET", Path: "/raw", Done: Handlers{func(ctx *Context) {
+			status, size, written = ctx.StatusCode(), ctx.ResponseWriter.Size(), ctx.ResponseWriter.Written()
+		}}, Handler: func(ctx *Context) {
+			ctx.ResponseWriter.WriteHeader(202)
+			io.WriteString(ctx.ResponseWriter, "hello")
+		}},
+		Entry{Method: "GET", Path: "/rewrite", Begin: Handlers{func(ctx *Context) { ctx.ResponseWriter.Buffer() }},
+			Done: Handlers{func(ctx *Context) {
+				b := ctx.ResponseWriter.Body()
+				ctx.ResponseWriter.SetBody([]byte(strings.ToUpper(string(b))))
+				ctx.ResponseWriter.WriteHeader(418)
+			}}, Handler: func(ctx *Context) {
+				ctx.SetStatusCode(200)
+				io.WriteString(ctx.ResponseWriter, "hello")
+			}},
+		Entry{Method: "GET", Path: "/t", Timeout: time.Second, Handler: func(ctx *Context) {
+			ctx.ResponseWriter.Buffer()
+			ctx.SetStatusCode(201)
+			io.WriteString(ctx.ResponseWriter, "buffered")
+		}},
+		Entry{Method: "GET", Path: "/log", Handler: NewLoggerHandler(&logs)},
+	})
+	rec := serve(qq, "GET", "/raw")
+	if rec.Code != 202 || status != 202 || size != 5 || !written {
+		t.Errorf("raw %d %d %d %v", rec.Code, status, size, written)
+	}
+	rec = serve(qq, "GET", "/rewrite")
+	if rec.Code != 418 || rec.Body.String() != "HELLO" {
+		t.Errorf("rewrite %d %q", rec.Code, rec.Body.String())
+	}
+	rec = serve(qq, "GET", "/t")
+	if rec.Code != 201 || rec.Body.String() != "buffered" {
+		t.Errorf("t %d %q", rec.Code, rec.Body.String())
+	}
+	var w ResponseWriter = &responseWriter{underline: rec}
+	var _ http.Flusher = w
+	w.Flush()
+	if _, _, err := w.Hijack(); err == nil {
+		t.Error("hijack")
+	}
+	_ = w.CloseNotify()
+}
+
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+	closed chan bool
+}
+
+func (r closeNotifyRecorder) CloseNotify() <-chan bool { return r.closed }
+
+func TestCloseNotify(t *testing.T) {
+	notified := make(chan bool, 2)
+	wait := func(ctx *Context) {
+		select {
+		case <-ctx.ResponseWriter.CloseNotify():
+			notified <- true
+		case <-time.After(time.Second):
+			notified <- false
+		}
+	}
+	qq := newQ(Entries{
+		Entry{Method: "GET", Path: "/", Handler: wait},
+		Entry{Method: "GET", Path: "/timeout", Timeout: time.Second, Handler: wait},
+	})
+	for _, path := range []string{"/", "/timeout"} {
+		rec := closeNotifyRecorder{ResponseRecorder: httptest.NewRecorder(), closed: make(chan bool, 1)}
+		rec.closed <- true
+		qq.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if !<-notified {
+			t.Errorf("%s: the close notification is not forwarded", path)
+		}
+	}
+}